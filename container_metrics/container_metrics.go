@@ -0,0 +1,266 @@
+// Package container_metrics periodically emits ContainerMetrics for a
+// container, with the CPU and memory usage read from its cgroup.
+//
+// Use
+//
+//	collector := container_metrics.New(sender, appID, instanceIndex, "/garden/abc123", 10*time.Second)
+//	go collector.Run(stopChan)
+//
+// Both cgroup v1, with the cpuacct and memory controllers mounted under the
+// cgroup root, and the cgroup v2 unified hierarchy are supported. Cgroups do
+// not account for disk usage; it is reported by the function given to
+// WithDiskUsage, if any.
+package container_metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+)
+
+// DefaultCgroupRoot is where the cgroup filesystem is usually mounted.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// unlimited is the smallest value cgroup v1 reports for a memory limit that
+// is not set.
+const unlimited = 1 << 62
+
+type MetricSender interface {
+	ContainerMetric(appID string, instance int32, cpu float64, mem, disk uint64) metric_sender.ContainerMetricChainer
+}
+
+// An Option configures a Collector created by New.
+type Option func(*Collector)
+
+// WithCgroupRoot reads cgroups from root instead of DefaultCgroupRoot.
+func WithCgroupRoot(root string) Option {
+	return func(c *Collector) {
+		c.root = root
+	}
+}
+
+// WithDiskUsage reports the disk usage and quota of the container, in
+// bytes, returned by usage.
+func WithDiskUsage(usage func() (used, quota uint64, err error)) Option {
+	return func(c *Collector) {
+		c.diskUsage = usage
+	}
+}
+
+// WithClock makes the collector use now for the time samples are taken at.
+func WithClock(now func() time.Time) Option {
+	return func(c *Collector) {
+		c.now = now
+	}
+}
+
+// A Collector emits ContainerMetrics for the container in one cgroup. The
+// memory and disk quotas are set if the sender's chainers implement
+// metric_sender.QuotaContainerMetricChainer.
+type Collector struct {
+	sender    MetricSender
+	appID     string
+	instance  int32
+	path      string
+	interval  time.Duration
+	root      string
+	diskUsage func() (uint64, uint64, error)
+	now       func() time.Time
+
+	previous *sample
+}
+
+type sample struct {
+	at          time.Time
+	cpuUsage    time.Duration
+	memory      uint64
+	memoryQuota uint64
+}
+
+// New creates a Collector for the container of the given app instance, whose
+// cgroup is at cgroupPath relative to the root of the hierarchy.
+func New(sender MetricSender, appID string, instance int32, cgroupPath string, interval time.Duration, opts ...Option) *Collector {
+	c := &Collector{
+		sender:   sender,
+		appID:    appID,
+		instance: instance,
+		path:     cgroupPath,
+		interval: interval,
+		root:     DefaultCgroupRoot,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run collects and emits a ContainerMetric every interval until stopChan is
+// closed. Errors are logged.
+func (c *Collector) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Collect(); err != nil {
+			log.Printf("ContainerMetrics: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// Collect reads the cgroup and emits a ContainerMetric. The CPU percentage is
+// computed from the CPU time used since the previous call, so the first call
+// only takes a sample and emits nothing. CPU percentages are relative to a
+// single core and exceed 100 when more than one core is used.
+func (c *Collector) Collect() error {
+	current, err := c.read()
+	if err != nil {
+		return err
+	}
+	previous := c.previous
+	c.previous = current
+	if previous == nil {
+		return nil
+	}
+
+	var cpu float64
+	if elapsed := current.at.Sub(previous.at); elapsed > 0 && current.cpuUsage >= previous.cpuUsage {
+		cpu = 100 * float64(current.cpuUsage-previous.cpuUsage) / float64(elapsed)
+	}
+
+	var disk, diskQuota uint64
+	if c.diskUsage != nil {
+		if disk, diskQuota, err = c.diskUsage(); err != nil {
+			return fmt.Errorf("failed to read disk usage: %v", err)
+		}
+	}
+
+	chainer := c.sender.ContainerMetric(c.appID, c.instance, cpu, current.memory, disk)
+	if quotas, ok := chainer.(metric_sender.QuotaContainerMetricChainer); ok {
+		chainer = quotas.SetMemoryQuota(current.memoryQuota).SetDiskQuota(diskQuota)
+	}
+	return chainer.Send()
+}
+
+func (c *Collector) read() (*sample, error) {
+	if _, err := os.Stat(filepath.Join(c.root, "cgroup.controllers")); err == nil {
+		return c.readV2()
+	}
+	return c.readV1()
+}
+
+func (c *Collector) readV1() (*sample, error) {
+	s := &sample{at: c.now()}
+
+	cpuDir := filepath.Join(c.root, "cpuacct", c.path)
+	usage, err := readUint(filepath.Join(cpuDir, "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	s.cpuUsage = time.Duration(usage)
+
+	memoryDir := filepath.Join(c.root, "memory", c.path)
+	if s.memory, err = workingSet(memoryDir, "memory.usage_in_bytes", "total_inactive_file"); err != nil {
+		return nil, err
+	}
+	quota, err := readUint(filepath.Join(memoryDir, "memory.limit_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	if quota < unlimited {
+		s.memoryQuota = quota
+	}
+	return s, nil
+}
+
+func (c *Collector) readV2() (*sample, error) {
+	s := &sample{at: c.now()}
+	dir := filepath.Join(c.root, c.path)
+
+	cpuStat, err := readFlatKeyed(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	usec, ok := cpuStat["usage_usec"]
+	if !ok {
+		return nil, fmt.Errorf("no usage_usec in %s", filepath.Join(dir, "cpu.stat"))
+	}
+	s.cpuUsage = time.Duration(usec) * time.Microsecond
+
+	if s.memory, err = workingSet(dir, "memory.current", "inactive_file"); err != nil {
+		return nil, err
+	}
+	max, err := os.ReadFile(filepath.Join(dir, "memory.max"))
+	if err != nil {
+		return nil, err
+	}
+	if value := strings.TrimSpace(string(max)); value != "max" {
+		if s.memoryQuota, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("malformed %s: %v", filepath.Join(dir, "memory.max"), err)
+		}
+	}
+	return s, nil
+}
+
+// workingSet returns the memory usage of a cgroup less its inactive file
+// cache, which the kernel reclaims before the cgroup runs out of memory.
+func workingSet(dir, usageFile, inactiveFileKey string) (uint64, error) {
+	usage, err := readUint(filepath.Join(dir, usageFile))
+	if err != nil {
+		return 0, err
+	}
+	stat, err := readFlatKeyed(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return 0, err
+	}
+	if inactive := stat[inactiveFileKey]; inactive < usage {
+		return usage - inactive, nil
+	}
+	return 0, nil
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed %s: %v", path, err)
+	}
+	return value, nil
+}
+
+// readFlatKeyed reads a file of "key value" lines, such as memory.stat.
+func readFlatKeyed(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
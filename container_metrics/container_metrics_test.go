@@ -0,0 +1,181 @@
+package container_metrics_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/container_metrics"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collector", func() {
+	var (
+		fakeEmitter *fake.FakeEventEmitter
+		root        string
+		now         time.Time
+		collector   *container_metrics.Collector
+	)
+
+	var copyFixture = func(name string) string {
+		dir := GinkgoT().TempDir()
+		src := filepath.Join("testdata", name)
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(src, path)
+			if d.IsDir() {
+				return os.MkdirAll(filepath.Join(dir, rel), 0755)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dir, rel), data, 0644)
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return dir
+	}
+
+	var write = func(path, content string) {
+		Expect(os.WriteFile(filepath.Join(root, path), []byte(content), 0644)).To(Succeed())
+	}
+
+	var containerMetrics = func() []*events.ContainerMetric {
+		var metrics []*events.ContainerMetric
+		for _, envelope := range fakeEmitter.GetEnvelopes() {
+			metrics = append(metrics, envelope.GetContainerMetric())
+		}
+		return metrics
+	}
+
+	var newCollector = func(opts ...container_metrics.Option) *container_metrics.Collector {
+		opts = append([]container_metrics.Option{
+			container_metrics.WithCgroupRoot(root),
+			container_metrics.WithClock(func() time.Time { return now }),
+		}, opts...)
+		return container_metrics.New(metric_sender.NewMetricSender(fakeEmitter), "app-id", 3, "garden/app", time.Hour, opts...)
+	}
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		now = time.Unix(1700000000, 0)
+	})
+
+	Context("with cgroup v1", func() {
+		BeforeEach(func() {
+			root = copyFixture("v1")
+			collector = newCollector()
+		})
+
+		It("emits nothing for the first sample", func() {
+			Expect(collector.Collect()).To(Succeed())
+			Expect(fakeEmitter.GetEnvelopes()).To(BeEmpty())
+		})
+
+		It("emits CPU usage between samples, memory and the memory quota", func() {
+			Expect(collector.Collect()).To(Succeed())
+			write("cpuacct/garden/app/cpuacct.usage", "6500000000\n")
+			now = now.Add(2 * time.Second)
+			Expect(collector.Collect()).To(Succeed())
+
+			Expect(containerMetrics()).To(HaveLen(1))
+			metric := containerMetrics()[0]
+			Expect(metric.GetApplicationId()).To(Equal("app-id"))
+			Expect(metric.GetInstanceIndex()).To(BeEquivalentTo(3))
+			Expect(metric.GetCpuPercentage()).To(BeNumerically("~", 75, 1e-9))
+			Expect(metric.GetMemoryBytes()).To(BeEquivalentTo(100*1024*1024 - 10*1024*1024))
+			Expect(metric.GetMemoryBytesQuota()).To(BeEquivalentTo(256 * 1024 * 1024))
+			Expect(metric.GetDiskBytes()).To(BeZero())
+		})
+
+		It("reports no memory quota when the cgroup is unlimited", func() {
+			write("memory/garden/app/memory.limit_in_bytes", "9223372036854771712\n")
+			Expect(collector.Collect()).To(Succeed())
+			now = now.Add(time.Second)
+			Expect(collector.Collect()).To(Succeed())
+
+			Expect(containerMetrics()[0].GetMemoryBytesQuota()).To(BeZero())
+		})
+	})
+
+	Context("with cgroup v2", func() {
+		BeforeEach(func() {
+			root = copyFixture("v2")
+			collector = newCollector()
+		})
+
+		It("emits CPU usage between samples, memory and the memory quota", func() {
+			Expect(collector.Collect()).To(Succeed())
+			write("garden/app/cpu.stat", "usage_usec 5500000\nuser_usec 4400000\nsystem_usec 1100000\n")
+			now = now.Add(time.Second)
+			Expect(collector.Collect()).To(Succeed())
+
+			Expect(containerMetrics()).To(HaveLen(1))
+			metric := containerMetrics()[0]
+			Expect(metric.GetCpuPercentage()).To(BeNumerically("~", 50, 1e-9))
+			Expect(metric.GetMemoryBytes()).To(BeEquivalentTo(100*1024*1024 - 10*1024*1024))
+			Expect(metric.GetMemoryBytesQuota()).To(BeEquivalentTo(256 * 1024 * 1024))
+		})
+
+		It("reports no memory quota when memory.max is max", func() {
+			write("garden/app/memory.max", "max\n")
+			Expect(collector.Collect()).To(Succeed())
+			now = now.Add(time.Second)
+			Expect(collector.Collect()).To(Succeed())
+
+			Expect(containerMetrics()[0].GetMemoryBytesQuota()).To(BeZero())
+		})
+
+		It("reports disk usage", func() {
+			collector = newCollector(container_metrics.WithDiskUsage(func() (uint64, uint64, error) {
+				return 1024, 4096, nil
+			}))
+			Expect(collector.Collect()).To(Succeed())
+			now = now.Add(time.Second)
+			Expect(collector.Collect()).To(Succeed())
+
+			Expect(containerMetrics()[0].GetDiskBytes()).To(BeEquivalentTo(1024))
+			Expect(containerMetrics()[0].GetDiskBytesQuota()).To(BeEquivalentTo(4096))
+		})
+
+		It("returns disk usage errors", func() {
+			collector = newCollector(container_metrics.WithDiskUsage(func() (uint64, uint64, error) {
+				return 0, 0, errors.New("statfs failed")
+			}))
+			Expect(collector.Collect()).To(Succeed())
+			Expect(collector.Collect()).To(MatchError(ContainSubstring("statfs failed")))
+		})
+	})
+
+	It("returns an error if the cgroup does not exist", func() {
+		root = copyFixture("v2")
+		collector = container_metrics.New(metric_sender.NewMetricSender(fakeEmitter), "app-id", 3, "garden/missing", time.Hour,
+			container_metrics.WithCgroupRoot(root))
+
+		Expect(collector.Collect()).To(MatchError(ContainSubstring("cpu.stat")))
+	})
+
+	It("emits on an interval", func() {
+		root = copyFixture("v1")
+		collector = container_metrics.New(metric_sender.NewMetricSender(fakeEmitter), "app-id", 3, "garden/app", 10*time.Millisecond,
+			container_metrics.WithCgroupRoot(root))
+		stopChan, runDone := make(chan struct{}), make(chan struct{})
+		go func() {
+			collector.Run(stopChan)
+			close(runDone)
+		}()
+
+		Eventually(func() int { return len(containerMetrics()) }).Should(BeNumerically(">=", 2))
+		close(stopChan)
+		Eventually(runDone).Should(BeClosed())
+	})
+})
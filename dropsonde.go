@@ -11,10 +11,13 @@
 package dropsonde
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
@@ -39,6 +42,19 @@ type EventEmitter interface {
 
 var (
 	DefaultEmitter EventEmitter = &NullEventEmitter{}
+
+	defaultTagsLock sync.Mutex
+	defaultTags     map[string]string
+	metricSender    *metric_sender.MetricSender
+	logSender       *log_sender.LogSender
+
+	heartbeatLock sync.Mutex
+	heartbeatTags map[string]string
+	heartbeatStop chan struct{}
+
+	processStatsLock    sync.Mutex
+	processStatsEnabled bool
+	processStatsStop    chan struct{}
 )
 
 const (
@@ -76,6 +92,103 @@ func InitializeWithEmitter(emitter EventEmitter) {
 	initialize()
 }
 
+// SetDefaultTags sets tags that are added to every metric and log message
+// sent through the senders created by Initialize, such as the availability
+// zone or environment of the process. It may be called before or after
+// Initialize. It returns an error, and leaves the default tags unchanged, if
+// there are more than 10 tags or a key or value is longer than 256
+// characters.
+func SetDefaultTags(tags map[string]string) error {
+	if err := metric_sender.ValidateTags(tags); err != nil {
+		return err
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+
+	defaultTagsLock.Lock()
+	defer defaultTagsLock.Unlock()
+	defaultTags = copied
+	if metricSender == nil {
+		return nil
+	}
+	return errors.Join(metricSender.SetDefaultTags(copied), logSender.SetDefaultTags(copied))
+}
+
+// EnableHeartbeat makes Initialize start a heartbeat alongside the runtime
+// stats. It periodically emits the uptime of the process and increments a
+// heartbeat counter, tagged with the module version, VCS revision and Go
+// version of the binary and the given version tags. If dropsonde is already
+// initialized, the heartbeat starts immediately.
+func EnableHeartbeat(versionTags map[string]string) {
+	tags := make(map[string]string, len(versionTags))
+	for k, v := range versionTags {
+		tags[k] = v
+	}
+
+	heartbeatLock.Lock()
+	heartbeatTags = tags
+	heartbeatLock.Unlock()
+
+	defaultTagsLock.Lock()
+	sender := metricSender
+	defaultTagsLock.Unlock()
+	if sender != nil {
+		startHeartbeat(sender)
+	}
+}
+
+// EnableProcessStats makes Initialize start emitting statistics about the
+// process, such as its memory, CPU time and open file descriptors, alongside
+// the runtime stats. They are only available on Linux. If dropsonde is
+// already initialized, the statistics start immediately.
+func EnableProcessStats() {
+	processStatsLock.Lock()
+	processStatsEnabled = true
+	processStatsLock.Unlock()
+
+	defaultTagsLock.Lock()
+	initialized := metricSender != nil
+	defaultTagsLock.Unlock()
+	if initialized {
+		startProcessStats()
+	}
+}
+
+// startProcessStats starts the process statistics, if enabled, stopping any
+// previous ones.
+func startProcessStats() {
+	processStatsLock.Lock()
+	defer processStatsLock.Unlock()
+
+	if processStatsStop != nil {
+		close(processStatsStop)
+		processStatsStop = nil
+	}
+	if !processStatsEnabled || runtime.GOOS != "linux" {
+		return
+	}
+	processStatsStop = make(chan struct{})
+	go runtime_stats.NewProcessStats(DefaultEmitter, statsInterval).Run(processStatsStop)
+}
+
+// startHeartbeat starts the heartbeat, if enabled, stopping any previous one.
+func startHeartbeat(sender *metric_sender.MetricSender) {
+	heartbeatLock.Lock()
+	defer heartbeatLock.Unlock()
+
+	if heartbeatStop != nil {
+		close(heartbeatStop)
+		heartbeatStop = nil
+	}
+	if heartbeatTags == nil {
+		return
+	}
+	heartbeatStop = make(chan struct{})
+	go runtime_stats.NewHeartbeat(sender, statsInterval, heartbeatTags).Run(heartbeatStop)
+}
+
 // AutowiredEmitter exposes the emitter used by Dropsonde after its initialization.
 func AutowiredEmitter() EventEmitter {
 	return DefaultEmitter
@@ -96,11 +209,21 @@ func InstrumentedRoundTripper(roundTripper http.RoundTripper) http.RoundTripper
 func initialize() {
 	emitter := AutowiredEmitter()
 	sender := metric_sender.NewMetricSender(emitter)
+	ls := log_sender.NewLogSender(AutowiredEmitter())
+
+	defaultTagsLock.Lock()
+	sender.SetDefaultTags(defaultTags)
+	ls.SetDefaultTags(defaultTags)
+	metricSender, logSender = sender, ls
+	defaultTagsLock.Unlock()
+
 	batcher := metricbatcher.New(sender, defaultBatchInterval)
 	metrics.Initialize(sender, batcher)
-	logs.Initialize(log_sender.NewLogSender(AutowiredEmitter()))
+	logs.Initialize(ls)
 	envelopes.Initialize(envelope_sender.NewEnvelopeSender(emitter))
 	go runtime_stats.NewRuntimeStats(DefaultEmitter, statsInterval).Run(nil)
+	startHeartbeat(sender)
+	startProcessStats()
 	http.DefaultTransport = InstrumentedRoundTripper(http.DefaultTransport)
 }
 
@@ -142,5 +265,17 @@ func (*NullEventEmitter) EmitEnvelope(*events.Envelope) error {
 	return nil
 }
 
+// EmitContext is called to send an event to a remote host. On
+// NullEventEmitter, it is a no-op.
+func (*NullEventEmitter) EmitContext(context.Context, events.Event) error {
+	return nil
+}
+
+// EmitEnvelopeContext is called to send an envelope to a remote host. On
+// NullEventEmitter, it is a no-op.
+func (*NullEventEmitter) EmitEnvelopeContext(context.Context, *events.Envelope) error {
+	return nil
+}
+
 // Close ceases emitter operations. On NullEventEmitter, it is a no-op.
 func (*NullEventEmitter) Close() {}
@@ -5,6 +5,10 @@ import (
 	"reflect"
 
 	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/logs"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -19,6 +23,54 @@ var _ = Describe("Autowire", func() {
 		})
 	})
 
+	Describe("SetDefaultTags", func() {
+		var fakeEmitter *fake.FakeEventEmitter
+
+		var findEnvelope = func(eventType events.Envelope_EventType, name string) *events.Envelope {
+			for _, envelope := range fakeEmitter.GetEnvelopes() {
+				if envelope.GetEventType() == eventType && (name == "" || envelope.GetValueMetric().GetName() == name) {
+					return envelope
+				}
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("origin")
+		})
+
+		AfterEach(func() {
+			Expect(dropsonde.SetDefaultTags(nil)).To(Succeed())
+		})
+
+		It("tags metrics and logs sent after Initialize", func() {
+			Expect(dropsonde.SetDefaultTags(map[string]string{"az": "z1"})).To(Succeed())
+			dropsonde.InitializeWithEmitter(fakeEmitter)
+
+			Expect(metrics.SendValue("defaultTagged", 1, "count")).To(Succeed())
+			Expect(logs.SendAppLog("app-id", "message", "App", "0")).To(Succeed())
+
+			Expect(findEnvelope(events.Envelope_ValueMetric, "defaultTagged").GetTags()).To(HaveKeyWithValue("az", "z1"))
+			Expect(findEnvelope(events.Envelope_LogMessage, "").GetTags()).To(HaveKeyWithValue("az", "z1"))
+		})
+
+		It("applies to senders that are already initialized", func() {
+			dropsonde.InitializeWithEmitter(fakeEmitter)
+			Expect(dropsonde.SetDefaultTags(map[string]string{"az": "z2"})).To(Succeed())
+
+			Expect(metrics.SendValue("defaultTagged", 1, "count")).To(Succeed())
+			Expect(findEnvelope(events.Envelope_ValueMetric, "defaultTagged").GetTags()).To(HaveKeyWithValue("az", "z2"))
+		})
+
+		It("rejects too many tags", func() {
+			tags := make(map[string]string)
+			for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"} {
+				tags[k] = "value"
+			}
+			Expect(dropsonde.SetDefaultTags(tags)).To(MatchError("Too many tags. Max of 10"))
+		})
+	})
+
 	Describe("CreateDefaultEmitter", func() {
 		Context("with origin missing", func() {
 			It("returns a NullEventEmitter", func() {
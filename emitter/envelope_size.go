@@ -0,0 +1,174 @@
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// MaxUDPPayloadSize is the largest payload a single IPv4 UDP datagram
+	// can carry.
+	MaxUDPPayloadSize = 65507
+
+	ChunkSequenceTag = "chunk_sequence"
+	ChunkTotalTag    = "chunk_total"
+
+	truncationMarker = "...[truncated]"
+
+	// maxLengthPrefixSize bounds the bytes a message's field key and length
+	// prefix can grow by, beyond the two bytes an empty message takes.
+	maxLengthPrefixSize = 4
+)
+
+// A SizeLimitedByteEmitter is a ByteEmitter whose transport cannot carry
+// payloads larger than MaxPayloadSize bytes. A size of zero means there is no
+// limit.
+type SizeLimitedByteEmitter interface {
+	ByteEmitter
+	MaxPayloadSize() int
+}
+
+// OversizedLogPolicy decides what an EventEmitter does with LogMessage
+// envelopes that are too large for its transport.
+type OversizedLogPolicy int
+
+const (
+	// SplitOversizedLogs sends the message in as many envelopes as needed,
+	// each tagged with its ChunkSequenceTag, starting at 1, and the
+	// ChunkTotalTag.
+	SplitOversizedLogs OversizedLogPolicy = iota
+	// TruncateOversizedLogs cuts the message short and marks it as
+	// truncated.
+	TruncateOversizedLogs
+	// RejectOversizedLogs returns an EnvelopeTooLargeError, as is always done
+	// for envelopes that do not carry a LogMessage.
+	RejectOversizedLogs
+)
+
+// EnvelopeTooLargeError is returned for envelopes that cannot be sent
+// because they exceed the transport's maximum payload size.
+type EnvelopeTooLargeError struct {
+	EventType events.Envelope_EventType
+	Size      int
+	MaxSize   int
+}
+
+func (e *EnvelopeTooLargeError) Error() string {
+	return fmt.Sprintf("%s envelope of %d bytes exceeds the maximum payload size of %d bytes", e.EventType, e.Size, e.MaxSize)
+}
+
+// SetOversizedLogPolicy sets how LogMessage envelopes that are too large for
+// the transport are handled. The default is SplitOversizedLogs.
+func (e *EventEmitter) SetOversizedLogPolicy(policy OversizedLogPolicy) {
+	e.oversizedLogPolicy = policy
+}
+
+func (e *EventEmitter) maxPayloadSize() int {
+	if sizeLimited, ok := e.innerEmitter.(SizeLimitedByteEmitter); ok {
+		return sizeLimited.MaxPayloadSize()
+	}
+	return 0
+}
+
+func (e *EventEmitter) emitOversized(ctx context.Context, bufPtr *[]byte, envelope *events.Envelope, size, maxSize int) error {
+	tooLarge := &EnvelopeTooLargeError{EventType: envelope.GetEventType(), Size: size, MaxSize: maxSize}
+	if envelope.GetEventType() != events.Envelope_LogMessage || envelope.GetLogMessage() == nil {
+		return tooLarge
+	}
+
+	switch e.oversizedLogPolicy {
+	case SplitOversizedLogs:
+		return e.emitSplit(ctx, bufPtr, envelope, maxSize, tooLarge)
+	case TruncateOversizedLogs:
+		return e.emitTruncated(ctx, bufPtr, envelope, maxSize, tooLarge)
+	default:
+		return tooLarge
+	}
+}
+
+func (e *EventEmitter) emitSplit(ctx context.Context, bufPtr *[]byte, envelope *events.Envelope, maxSize int, tooLarge error) error {
+	message := envelope.GetLogMessage().GetMessage()
+	chunk := proto.Clone(envelope).(*events.Envelope)
+	if chunk.Tags == nil {
+		chunk.Tags = make(map[string]string)
+	}
+
+	// Size the chunks for the widest tag values they could carry: there are
+	// never more chunks than bytes in the message.
+	widest := strconv.Itoa(len(message))
+	chunk.Tags[ChunkSequenceTag] = widest
+	chunk.Tags[ChunkTotalTag] = widest
+	chunks := splitUTF8(message, available(chunk, maxSize))
+	if chunks == nil {
+		return tooLarge
+	}
+
+	total := strconv.Itoa(len(chunks))
+	for i, part := range chunks {
+		chunk.Tags[ChunkSequenceTag] = strconv.Itoa(i + 1)
+		chunk.Tags[ChunkTotalTag] = total
+		chunk.LogMessage.Message = part
+
+		if err := e.emitMarshalled(ctx, bufPtr, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EventEmitter) emitTruncated(ctx context.Context, bufPtr *[]byte, envelope *events.Envelope, maxSize int, tooLarge error) error {
+	message := envelope.GetLogMessage().GetMessage()
+	truncated := proto.Clone(envelope).(*events.Envelope)
+
+	limit := available(truncated, maxSize) - len(truncationMarker)
+	parts := splitUTF8(message, limit)
+	if parts == nil {
+		return tooLarge
+	}
+	truncated.LogMessage.Message = append(parts[0], truncationMarker...)
+
+	return e.emitMarshalled(ctx, bufPtr, truncated)
+}
+
+func (e *EventEmitter) emitMarshalled(ctx context.Context, bufPtr *[]byte, envelope *events.Envelope) error {
+	data, err := marshalInto(bufPtr, envelope)
+	if err != nil {
+		return fmt.Errorf("Marshal: %v", err)
+	}
+	return emitContext(ctx, e.innerEmitter, data)
+}
+
+// available returns how many message bytes fit into maxSize alongside the
+// rest of the log envelope. It leaves the envelope's message empty.
+func available(envelope *events.Envelope, maxSize int) int {
+	envelope.LogMessage.Message = []byte{}
+	return maxSize - proto.Size(envelope) - maxLengthPrefixSize
+}
+
+// splitUTF8 splits message into parts of at most size bytes without breaking
+// up multi-byte characters. It returns nil if size is too small to make
+// progress.
+func splitUTF8(message []byte, size int) [][]byte {
+	if size < utf8.UTFMax {
+		return nil
+	}
+
+	var parts [][]byte
+	for len(message) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		if end == 0 {
+			end = size
+		}
+		parts = append(parts, message[:end:end])
+		message = message[end:]
+	}
+	return append(parts, message[:len(message):len(message)])
+}
@@ -0,0 +1,130 @@
+package emitter_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Oversized envelopes", func() {
+	var (
+		innerEmitter *fake.FakeByteEmitter
+		eventEmitter *emitter.EventEmitter
+	)
+
+	var receivedEnvelopes = func() []*events.Envelope {
+		var envelopes []*events.Envelope
+		for _, msg := range innerEmitter.GetMessages() {
+			Expect(len(msg)).To(BeNumerically("<=", innerEmitter.PayloadSizeLimit))
+
+			envelope := &events.Envelope{}
+			Expect(proto.Unmarshal(msg, envelope)).To(Succeed())
+			envelopes = append(envelopes, envelope)
+		}
+		return envelopes
+	}
+
+	BeforeEach(func() {
+		innerEmitter = fake.NewFakeByteEmitter()
+		innerEmitter.PayloadSizeLimit = 256
+		eventEmitter = emitter.NewEventEmitter(innerEmitter, "origin")
+	})
+
+	It("sends envelopes within the limit unchanged", func() {
+		err := eventEmitter.Emit(factories.NewLogMessage(events.LogMessage_OUT, "short", "app-id", "APP"))
+		Expect(err).ToNot(HaveOccurred())
+
+		envelopes := receivedEnvelopes()
+		Expect(envelopes).To(HaveLen(1))
+		Expect(envelopes[0].GetTags()).To(BeEmpty())
+	})
+
+	It("splits oversized log messages into ordered chunks by default", func() {
+		message := strings.Repeat("abcdefghij", 100)
+		logMessage := factories.NewLogMessage(events.LogMessage_OUT, message, "app-id", "APP")
+
+		err := eventEmitter.Emit(logMessage)
+		Expect(err).ToNot(HaveOccurred())
+
+		envelopes := receivedEnvelopes()
+		Expect(len(envelopes)).To(BeNumerically(">", 1))
+
+		var reassembled []byte
+		for i, envelope := range envelopes {
+			Expect(envelope.GetTags()).To(HaveKeyWithValue(emitter.ChunkSequenceTag, strconv.Itoa(i+1)))
+			Expect(envelope.GetTags()).To(HaveKeyWithValue(emitter.ChunkTotalTag, strconv.Itoa(len(envelopes))))
+			Expect(envelope.GetLogMessage().GetAppId()).To(Equal("app-id"))
+			reassembled = append(reassembled, envelope.GetLogMessage().GetMessage()...)
+		}
+		Expect(string(reassembled)).To(Equal(message))
+		Expect(logMessage.GetMessage()).To(BeEquivalentTo(message))
+	})
+
+	It("does not split multi-byte characters", func() {
+		message := strings.Repeat("Ωmega", 100)
+
+		err := eventEmitter.Emit(factories.NewLogMessage(events.LogMessage_OUT, message, "app-id", "APP"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var reassembled []byte
+		for _, envelope := range receivedEnvelopes() {
+			Expect(utf8.Valid(envelope.GetLogMessage().GetMessage())).To(BeTrue())
+			reassembled = append(reassembled, envelope.GetLogMessage().GetMessage()...)
+		}
+		Expect(string(reassembled)).To(Equal(message))
+	})
+
+	It("truncates oversized log messages with a marker", func() {
+		eventEmitter.SetOversizedLogPolicy(emitter.TruncateOversizedLogs)
+		message := strings.Repeat("x", 1000)
+
+		err := eventEmitter.Emit(factories.NewLogMessage(events.LogMessage_OUT, message, "app-id", "APP"))
+		Expect(err).ToNot(HaveOccurred())
+
+		envelopes := receivedEnvelopes()
+		Expect(envelopes).To(HaveLen(1))
+		truncated := string(envelopes[0].GetLogMessage().GetMessage())
+		Expect(truncated).To(HaveSuffix("...[truncated]"))
+		Expect(truncated).To(HavePrefix("xxxx"))
+	})
+
+	It("rejects oversized log messages with a typed error", func() {
+		eventEmitter.SetOversizedLogPolicy(emitter.RejectOversizedLogs)
+
+		err := eventEmitter.Emit(factories.NewLogMessage(events.LogMessage_OUT, strings.Repeat("x", 1000), "app-id", "APP"))
+
+		var tooLarge *emitter.EnvelopeTooLargeError
+		Expect(errors.As(err, &tooLarge)).To(BeTrue())
+		Expect(tooLarge.EventType).To(Equal(events.Envelope_LogMessage))
+		Expect(tooLarge.MaxSize).To(Equal(256))
+		Expect(innerEmitter.GetMessages()).To(BeEmpty())
+	})
+
+	It("rejects other oversized envelopes before writing them", func() {
+		err := eventEmitter.Emit(factories.NewValueMetric(strings.Repeat("x", 1000), 1, "count"))
+
+		var tooLarge *emitter.EnvelopeTooLargeError
+		Expect(errors.As(err, &tooLarge)).To(BeTrue())
+		Expect(tooLarge.EventType).To(Equal(events.Envelope_ValueMetric))
+		Expect(tooLarge.Size).To(BeNumerically(">", 1000))
+		Expect(innerEmitter.GetMessages()).To(BeEmpty())
+	})
+
+	It("knows the UDP payload limit", func() {
+		udpEmitter, err := emitter.NewUdpEmitter("localhost:3457")
+		Expect(err).ToNot(HaveOccurred())
+		defer udpEmitter.Close()
+
+		Expect(udpEmitter.MaxPayloadSize()).To(Equal(emitter.MaxUDPPayloadSize))
+	})
+})
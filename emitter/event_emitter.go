@@ -1,20 +1,50 @@
 package emitter
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/cloudfoundry/sonde-go/events"
 	"google.golang.org/protobuf/proto"
 )
 
+// maxPooledBufferSize bounds the marshal buffers kept for reuse, so that an
+// occasional huge envelope does not pin its buffer in memory.
+const maxPooledBufferSize = 64 * 1024
+
+var marshalBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
 type ByteEmitter interface {
 	Emit([]byte) error
 	Close()
 }
 
+// NonRetainingByteEmitter is implemented by ByteEmitters that, as with
+// io.Writer, do not retain the data given to Emit once it returns. The
+// EventEmitter marshals envelopes for them into buffers it reuses, while
+// other ByteEmitters are given a new slice for every envelope.
+type NonRetainingByteEmitter interface {
+	ByteEmitter
+	NonRetaining()
+}
+
+// ContextByteEmitter is implemented by ByteEmitters whose writes can block,
+// allowing a context's deadline and cancellation to bound them.
+type ContextByteEmitter interface {
+	ByteEmitter
+	EmitContext(context.Context, []byte) error
+}
+
 type EventEmitter struct {
-	innerEmitter ByteEmitter
-	origin       string
+	innerEmitter       ByteEmitter
+	origin             string
+	oversizedLogPolicy OversizedLogPolicy
 }
 
 func NewEventEmitter(byteEmitter ByteEmitter, origin string) *EventEmitter {
@@ -26,23 +56,79 @@ func (e *EventEmitter) Origin() string {
 }
 
 func (e *EventEmitter) Emit(event events.Event) error {
-	envelope, err := Wrap(event, e.origin)
+	return e.EmitContext(context.Background(), event)
+}
+
+// EmitContext is like Emit, but gives up once ctx is done.
+func (e *EventEmitter) EmitContext(ctx context.Context, event events.Event) error {
+	wrapped, err := wrapPooled(event, e.origin)
 	if err != nil {
 		return fmt.Errorf("Wrap: %v", err)
 	}
+	defer wrapped.release()
 
-	return e.EmitEnvelope(envelope)
+	return e.EmitEnvelopeContext(ctx, &wrapped.envelope)
 }
 
 func (e *EventEmitter) EmitEnvelope(envelope *events.Envelope) error {
-	data, err := proto.Marshal(envelope)
+	return e.EmitEnvelopeContext(context.Background(), envelope)
+}
+
+// EmitEnvelopeContext is like EmitEnvelope, but gives up once ctx is done.
+func (e *EventEmitter) EmitEnvelopeContext(ctx context.Context, envelope *events.Envelope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var bufPtr *[]byte
+	if _, ok := e.innerEmitter.(NonRetainingByteEmitter); ok {
+		bufPtr = marshalBufferPool.Get().(*[]byte)
+		defer func() {
+			if cap(*bufPtr) <= maxPooledBufferSize {
+				marshalBufferPool.Put(bufPtr)
+			}
+		}()
+	}
+
+	data, err := marshalInto(bufPtr, envelope)
 	if err != nil {
 		return fmt.Errorf("Marshal: %v", err)
 	}
 
-	return e.innerEmitter.Emit(data)
+	if maxSize := e.maxPayloadSize(); maxSize > 0 && len(data) > maxSize {
+		return e.emitOversized(ctx, bufPtr, envelope, len(data), maxSize)
+	}
+
+	return emitContext(ctx, e.innerEmitter, data)
 }
 
 func (e *EventEmitter) Close() {
 	e.innerEmitter.Close()
 }
+
+// marshalInto marshals envelope into the buffer bufPtr points to, growing it
+// as necessary. If bufPtr is nil the envelope is marshalled into a new slice.
+func marshalInto(bufPtr *[]byte, envelope *events.Envelope) ([]byte, error) {
+	if bufPtr == nil {
+		return proto.Marshal(envelope)
+	}
+	data, err := proto.MarshalOptions{}.MarshalAppend((*bufPtr)[:0], envelope)
+	if err != nil {
+		return nil, err
+	}
+	*bufPtr = data
+	return data, nil
+}
+
+// emitContext hands data to byteEmitter, passing ctx along if the emitter
+// supports it. Emitters that do not are only called if ctx is not yet done.
+func emitContext(ctx context.Context, byteEmitter ByteEmitter, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if contextEmitter, ok := byteEmitter.(ContextByteEmitter); ok {
+		return contextEmitter.EmitContext(ctx, data)
+	}
+	return byteEmitter.Emit(data)
+}
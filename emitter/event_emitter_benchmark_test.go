@@ -0,0 +1,84 @@
+package emitter_test
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+)
+
+type discardByteEmitter struct{}
+
+func (discardByteEmitter) Emit([]byte) error { return nil }
+func (discardByteEmitter) NonRetaining()     {}
+func (discardByteEmitter) Close()            {}
+
+func BenchmarkEventEmitterEmitValueMetric(b *testing.B) {
+	eventEmitter := emitter.NewEventEmitter(discardByteEmitter{}, "origin")
+	metric := factories.NewValueMetric("metric-name", 1.5, "ms")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := eventEmitter.Emit(metric); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEventEmitterEmitHttpStartStop(b *testing.B) {
+	eventEmitter := emitter.NewEventEmitter(discardByteEmitter{}, "origin")
+	httpStartStop := &events.HttpStartStop{
+		StartTimestamp: proto.Int64(1),
+		StopTimestamp:  proto.Int64(2),
+		RequestId:      &events.UUID{Low: proto.Uint64(1), High: proto.Uint64(2)},
+		PeerType:       events.PeerType_Server.Enum(),
+		Method:         events.Method_GET.Enum(),
+		Uri:            proto.String("http://example.com/some/path"),
+		RemoteAddress:  proto.String("10.0.0.1:1234"),
+		UserAgent:      proto.String("curl/7.0"),
+		StatusCode:     proto.Int32(200),
+		ContentLength:  proto.Int64(1024),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := eventEmitter.Emit(httpStartStop); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEventEmitterEmitEnvelope(b *testing.B) {
+	eventEmitter := emitter.NewEventEmitter(discardByteEmitter{}, "origin")
+	envelope, err := emitter.Wrap(factories.NewValueMetric("metric-name", 1.5, "ms"), "origin")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := eventEmitter.EmitEnvelope(envelope); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUDPEmitterEmit(b *testing.B) {
+	udpEmitter, err := emitter.NewUdpEmitter("127.0.0.1:3457")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer udpEmitter.Close()
+	data := make([]byte, 128)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		udpEmitter.Emit(data)
+	}
+}
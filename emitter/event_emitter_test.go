@@ -1,6 +1,8 @@
 package emitter_test
 
 import (
+	"context"
+
 	"github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	"github.com/cloudfoundry/dropsonde/factories"
@@ -52,6 +54,19 @@ var _ = Describe("EventEmitter", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(envelope.GetEventType()).To(Equal(events.Envelope_ValueMetric))
 		})
+
+		It("gives emitters that may retain the data a new slice for every event", func() {
+			innerEmitter := &retainingByteEmitter{}
+			eventEmitter := emitter.NewEventEmitter(innerEmitter, "fake-origin")
+
+			Expect(eventEmitter.Emit(factories.NewValueMetric("first", 1, "ms"))).To(Succeed())
+			Expect(eventEmitter.Emit(factories.NewValueMetric("second", 2, "ms"))).To(Succeed())
+
+			Expect(innerEmitter.messages).To(HaveLen(2))
+			var envelope events.Envelope
+			Expect(proto.Unmarshal(innerEmitter.messages[0], &envelope)).To(Succeed())
+			Expect(envelope.GetValueMetric().GetName()).To(Equal("first"))
+		})
 	})
 
 	Describe("EmitEnvelope", func() {
@@ -89,6 +104,33 @@ var _ = Describe("EventEmitter", func() {
 		})
 	})
 
+	Describe("EmitContext", func() {
+		It("marshals events and delegates to the inner emitter", func() {
+			innerEmitter := fake.NewFakeByteEmitter()
+			eventEmitter := emitter.NewEventEmitter(innerEmitter, "fake-origin")
+
+			err := eventEmitter.EmitContext(context.Background(), factories.NewValueMetric("metric-name", 2.0, "metric-unit"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+		})
+
+		It("does not emit once the context is done", func() {
+			innerEmitter := fake.NewFakeByteEmitter()
+			eventEmitter := emitter.NewEventEmitter(innerEmitter, "fake-origin")
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := eventEmitter.EmitContext(ctx, factories.NewValueMetric("metric-name", 2.0, "metric-unit"))
+			Expect(err).To(MatchError(context.Canceled))
+
+			err = eventEmitter.EmitEnvelopeContext(ctx, &events.Envelope{})
+			Expect(err).To(MatchError(context.Canceled))
+
+			Expect(innerEmitter.GetMessages()).To(BeEmpty())
+		})
+	})
+
 	Describe("Close", func() {
 		It("closes the inner emitter", func() {
 			innerEmitter := fake.NewFakeByteEmitter()
@@ -99,3 +141,16 @@ var _ = Describe("EventEmitter", func() {
 		})
 	})
 })
+
+// retainingByteEmitter keeps the slices it is given, as a ByteEmitter that
+// queues data to write later would.
+type retainingByteEmitter struct {
+	messages [][]byte
+}
+
+func (e *retainingByteEmitter) Emit(data []byte) error {
+	e.messages = append(e.messages, data)
+	return nil
+}
+
+func (e *retainingByteEmitter) Close() {}
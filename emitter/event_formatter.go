@@ -2,41 +2,90 @@ package emitter
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry/sonde-go/events"
-	"google.golang.org/protobuf/proto"
 )
 
 var ErrorMissingOrigin = errors.New("Event not emitted due to missing origin information")
 var ErrorUnknownEventType = errors.New("Cannot create envelope for unknown event type")
 
+// wrappedEvent is an envelope together with the storage for its scalar
+// fields, so that wrapping an event costs at most a single allocation.
+type wrappedEvent struct {
+	envelope  events.Envelope
+	origin    string
+	eventType events.Envelope_EventType
+	timestamp int64
+}
+
+var wrappedEventPool = sync.Pool{
+	New: func() interface{} {
+		return new(wrappedEvent)
+	},
+}
+
 func Wrap(event events.Event, origin string) (*events.Envelope, error) {
 	if origin == "" {
 		return nil, ErrorMissingOrigin
 	}
 
-	envelope := &events.Envelope{Origin: proto.String(origin), Timestamp: proto.Int64(time.Now().UnixNano())}
+	w := new(wrappedEvent)
+	if err := w.wrap(event, origin); err != nil {
+		return nil, err
+	}
+	return &w.envelope, nil
+}
+
+// wrapPooled is like Wrap, but takes the envelope from a pool. The envelope
+// must be handed back with release once it is no longer referenced.
+func wrapPooled(event events.Event, origin string) (*wrappedEvent, error) {
+	if origin == "" {
+		return nil, ErrorMissingOrigin
+	}
+
+	w := wrappedEventPool.Get().(*wrappedEvent)
+	if err := w.wrap(event, origin); err != nil {
+		w.release()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wrappedEvent) wrap(event events.Event, origin string) error {
+	envelope := &w.envelope
 
 	switch event := event.(type) {
 	case *events.HttpStartStop:
-		envelope.EventType = events.Envelope_HttpStartStop.Enum()
+		w.eventType = events.Envelope_HttpStartStop
 		envelope.HttpStartStop = event
 	case *events.ValueMetric:
-		envelope.EventType = events.Envelope_ValueMetric.Enum()
+		w.eventType = events.Envelope_ValueMetric
 		envelope.ValueMetric = event
 	case *events.CounterEvent:
-		envelope.EventType = events.Envelope_CounterEvent.Enum()
+		w.eventType = events.Envelope_CounterEvent
 		envelope.CounterEvent = event
 	case *events.LogMessage:
-		envelope.EventType = events.Envelope_LogMessage.Enum()
+		w.eventType = events.Envelope_LogMessage
 		envelope.LogMessage = event
 	case *events.ContainerMetric:
-		envelope.EventType = events.Envelope_ContainerMetric.Enum()
+		w.eventType = events.Envelope_ContainerMetric
 		envelope.ContainerMetric = event
 	default:
-		return nil, ErrorUnknownEventType
+		return ErrorUnknownEventType
 	}
 
-	return envelope, nil
+	w.origin = origin
+	w.timestamp = time.Now().UnixNano()
+	envelope.Origin = &w.origin
+	envelope.Timestamp = &w.timestamp
+	envelope.EventType = &w.eventType
+	return nil
+}
+
+func (w *wrappedEvent) release() {
+	w.envelope.Reset()
+	w.origin = ""
+	wrappedEventPool.Put(w)
 }
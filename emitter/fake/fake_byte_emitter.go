@@ -6,9 +6,12 @@ import (
 
 type FakeByteEmitter struct {
 	ReturnError error
-	Messages    [][]byte
-	mutex       *sync.RWMutex
-	isClosed    bool
+	// PayloadSizeLimit is returned by MaxPayloadSize. Zero means there is
+	// no limit.
+	PayloadSizeLimit int
+	Messages         [][]byte
+	mutex            *sync.RWMutex
+	isClosed         bool
 }
 
 func NewFakeByteEmitter() *FakeByteEmitter {
@@ -25,10 +28,18 @@ func (f *FakeByteEmitter) Emit(data []byte) (err error) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	f.Messages = append(f.Messages, data)
+	f.Messages = append(f.Messages, append([]byte(nil), data...))
 	return
 }
 
+// NonRetaining marks FakeByteEmitter as a NonRetainingByteEmitter, as it
+// copies the data it records.
+func (f *FakeByteEmitter) NonRetaining() {}
+
+func (f *FakeByteEmitter) MaxPayloadSize() int {
+	return f.PayloadSizeLimit
+}
+
 func (f *FakeByteEmitter) GetMessages() (messages [][]byte) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
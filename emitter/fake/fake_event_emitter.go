@@ -1,6 +1,7 @@
 package fake
 
 import (
+	"context"
 	"sync"
 
 	"github.com/cloudfoundry/sonde-go/events"
@@ -60,6 +61,20 @@ func (f *FakeEventEmitter) EmitEnvelope(e *events.Envelope) error {
 	return nil
 }
 
+func (f *FakeEventEmitter) EmitContext(ctx context.Context, e events.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Emit(e)
+}
+
+func (f *FakeEventEmitter) EmitEnvelopeContext(ctx context.Context, e *events.Envelope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.EmitEnvelope(e)
+}
+
 func (f *FakeEventEmitter) GetMessages() (messages []Message) {
 	f.Lock()
 	defer f.Unlock()
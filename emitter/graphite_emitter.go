@@ -0,0 +1,69 @@
+package emitter
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var graphitePathReplacer = strings.NewReplacer(" ", "_", "\t", "_", "\n", "_", "/", ".")
+
+// GraphiteEmitter writes ValueMetric and CounterEvent envelopes in the
+// Graphite plaintext protocol, one "origin.name value timestamp" line per
+// envelope. Counters are written with their total when the envelope carries
+// one and with their delta otherwise. All other envelopes are dropped.
+type GraphiteEmitter struct {
+	lineEmitter
+}
+
+// NewGraphiteEmitter creates a GraphiteEmitter writing to byteEmitter,
+// typically a TCPEmitter.
+func NewGraphiteEmitter(byteEmitter ByteEmitter, origin string) *GraphiteEmitter {
+	return &GraphiteEmitter{
+		lineEmitter: lineEmitter{
+			innerEmitter: byteEmitter,
+			origin:       origin,
+			formatLine:   formatGraphite,
+		},
+	}
+}
+
+func formatGraphite(envelope *events.Envelope) []byte {
+	var name, value string
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		metric := envelope.GetValueMetric()
+		name = metric.GetName()
+		value = strconv.FormatFloat(metric.GetValue(), 'f', -1, 64)
+	case events.Envelope_CounterEvent:
+		counter := envelope.GetCounterEvent()
+		name = counter.GetName()
+		if counter.Total != nil {
+			value = strconv.FormatUint(counter.GetTotal(), 10)
+		} else {
+			value = strconv.FormatUint(counter.GetDelta(), 10)
+		}
+	default:
+		return nil
+	}
+
+	path := graphitePathReplacer.Replace(name)
+	if origin := envelope.GetOrigin(); origin != "" {
+		path = graphitePathReplacer.Replace(origin) + "." + path
+	}
+
+	timestamp := envelope.GetTimestamp()
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano()
+	}
+
+	line := make([]byte, 0, len(path)+len(value)+13)
+	line = append(line, path...)
+	line = append(line, ' ')
+	line = append(line, value...)
+	line = append(line, ' ')
+	line = strconv.AppendInt(line, timestamp/int64(time.Second), 10)
+	return append(line, '\n')
+}
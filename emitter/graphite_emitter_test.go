@@ -0,0 +1,81 @@
+package emitter_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GraphiteEmitter", func() {
+	var (
+		innerEmitter    *fake.FakeByteEmitter
+		graphiteEmitter *emitter.GraphiteEmitter
+		timestamp       int64
+	)
+
+	BeforeEach(func() {
+		innerEmitter = fake.NewFakeByteEmitter()
+		graphiteEmitter = emitter.NewGraphiteEmitter(innerEmitter, "router/z1/0")
+		timestamp = time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC).UnixNano()
+	})
+
+	It("returns the origin", func() {
+		Expect(graphiteEmitter.Origin()).To(Equal("router/z1/0"))
+	})
+
+	It("writes value metrics as plaintext lines", func() {
+		err := graphiteEmitter.EmitEnvelope(&events.Envelope{
+			Origin:      proto.String("router/z1/0"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			Timestamp:   proto.Int64(timestamp),
+			ValueMetric: factories.NewValueMetric("request latency", 1.5, "ms"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+		Expect(string(innerEmitter.GetMessages()[0])).To(Equal("router.z1.0.request_latency 1.5 1488603967\n"))
+	})
+
+	It("writes counters with their total when present", func() {
+		err := graphiteEmitter.EmitEnvelope(&events.Envelope{
+			Origin:    proto.String("router"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			Timestamp: proto.Int64(timestamp),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("requests"),
+				Delta: proto.Uint64(2),
+				Total: proto.Uint64(42),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(innerEmitter.GetMessages()[0])).To(Equal("router.requests 42 1488603967\n"))
+	})
+
+	It("writes counters with their delta otherwise", func() {
+		err := graphiteEmitter.Emit(factories.NewCounterEvent("requests", 2))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+		Expect(string(innerEmitter.GetMessages()[0])).To(MatchRegexp(`^router\.z1\.0\.requests 2 \d+\n$`))
+	})
+
+	It("drops other events", func() {
+		err := graphiteEmitter.Emit(factories.NewLogMessage(events.LogMessage_OUT, "hi", "app", "APP"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(innerEmitter.GetMessages()).To(BeEmpty())
+	})
+
+	It("closes the inner emitter", func() {
+		graphiteEmitter.Close()
+		Expect(innerEmitter.IsClosed()).To(BeTrue())
+	})
+})
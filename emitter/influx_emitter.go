@@ -0,0 +1,115 @@
+package emitter
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// Line protocol has no escape for line breaks, so they are written as an
+// escaped space rather than ending the line early.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+)
+
+// InfluxEmitter writes ValueMetric and CounterEvent envelopes in the InfluxDB
+// line protocol. The metric name is used as the measurement and the
+// envelope's origin, deployment, job, index and ip, along with its tags, as
+// the tag set. All other envelopes are dropped, as are values that are NaN or
+// infinite, which InfluxDB rejects. Counter deltas and totals beyond the
+// range of InfluxDB integers are written as the largest one.
+type InfluxEmitter struct {
+	lineEmitter
+}
+
+// NewInfluxEmitter creates an InfluxEmitter writing to byteEmitter,
+// typically a TCPEmitter.
+func NewInfluxEmitter(byteEmitter ByteEmitter, origin string) *InfluxEmitter {
+	return &InfluxEmitter{
+		lineEmitter: lineEmitter{
+			innerEmitter: byteEmitter,
+			origin:       origin,
+			formatLine:   formatInflux,
+		},
+	}
+}
+
+func formatInflux(envelope *events.Envelope) []byte {
+	tags := map[string]string{
+		"origin":     envelope.GetOrigin(),
+		"deployment": envelope.GetDeployment(),
+		"job":        envelope.GetJob(),
+		"index":      envelope.GetIndex(),
+		"ip":         envelope.GetIp(),
+	}
+	for k, v := range envelope.GetTags() {
+		tags[k] = v
+	}
+
+	var name string
+	var fields []byte
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		metric := envelope.GetValueMetric()
+		if math.IsNaN(metric.GetValue()) || math.IsInf(metric.GetValue(), 0) {
+			return nil
+		}
+		name = metric.GetName()
+		tags["unit"] = metric.GetUnit()
+		fields = append(fields, "value="...)
+		fields = strconv.AppendFloat(fields, metric.GetValue(), 'f', -1, 64)
+	case events.Envelope_CounterEvent:
+		counter := envelope.GetCounterEvent()
+		name = counter.GetName()
+		fields = append(fields, "delta="...)
+		fields = appendInfluxInt(fields, counter.GetDelta())
+		if counter.Total != nil {
+			fields = append(fields, ",total="...)
+			fields = appendInfluxInt(fields, counter.GetTotal())
+		}
+	default:
+		return nil
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if k == "" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	timestamp := envelope.GetTimestamp()
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano()
+	}
+
+	line := []byte(influxMeasurementEscaper.Replace(name))
+	for _, k := range keys {
+		line = append(line, ',')
+		line = append(line, influxTagEscaper.Replace(k)...)
+		line = append(line, '=')
+		line = append(line, influxTagEscaper.Replace(tags[k])...)
+	}
+	line = append(line, ' ')
+	line = append(line, fields...)
+	line = append(line, ' ')
+	line = strconv.AppendInt(line, timestamp, 10)
+	return append(line, '\n')
+}
+
+// appendInfluxInt appends v as a signed integer field, clamped to the range
+// of one.
+func appendInfluxInt(fields []byte, v uint64) []byte {
+	if v > math.MaxInt64 {
+		v = math.MaxInt64
+	}
+	fields = strconv.AppendUint(fields, v, 10)
+	return append(fields, 'i')
+}
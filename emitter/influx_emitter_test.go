@@ -0,0 +1,119 @@
+package emitter_test
+
+import (
+	"math"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InfluxEmitter", func() {
+	var (
+		innerEmitter  *fake.FakeByteEmitter
+		influxEmitter *emitter.InfluxEmitter
+		timestamp     int64
+	)
+
+	BeforeEach(func() {
+		innerEmitter = fake.NewFakeByteEmitter()
+		influxEmitter = emitter.NewInfluxEmitter(innerEmitter, "router")
+		timestamp = time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC).UnixNano()
+	})
+
+	It("writes value metrics with tags and the unit", func() {
+		err := influxEmitter.EmitEnvelope(&events.Envelope{
+			Origin:      proto.String("router"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			Timestamp:   proto.Int64(timestamp),
+			Job:         proto.String("router_z1"),
+			Index:       proto.String("0"),
+			Tags:        map[string]string{"az": "z 1", "k=v": "a,b"},
+			ValueMetric: factories.NewValueMetric("request latency", 1.5, "ms"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+		Expect(string(innerEmitter.GetMessages()[0])).To(Equal(
+			`request\ latency,az=z\ 1,index=0,job=router_z1,k\=v=a\,b,origin=router,unit=ms value=1.5 1488603967000000000` + "\n",
+		))
+	})
+
+	It("writes counters with their delta and total", func() {
+		err := influxEmitter.EmitEnvelope(&events.Envelope{
+			Origin:    proto.String("router"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			Timestamp: proto.Int64(timestamp),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("requests"),
+				Delta: proto.Uint64(2),
+				Total: proto.Uint64(42),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(innerEmitter.GetMessages()[0])).To(Equal("requests,origin=router delta=2i,total=42i 1488603967000000000\n"))
+	})
+
+	It("omits the total when the counter has none", func() {
+		err := influxEmitter.Emit(factories.NewCounterEvent("requests", 2))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(innerEmitter.GetMessages()[0])).To(MatchRegexp(`^requests,origin=router delta=2i \d+\n$`))
+	})
+
+	It("clamps counters beyond the range of integers", func() {
+		err := influxEmitter.EmitEnvelope(&events.Envelope{
+			Origin:    proto.String("router"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			Timestamp: proto.Int64(timestamp),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("requests"),
+				Delta: proto.Uint64(math.MaxUint64),
+				Total: proto.Uint64(math.MaxInt64 + 1),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(innerEmitter.GetMessages()[0])).To(Equal(
+			"requests,origin=router delta=9223372036854775807i,total=9223372036854775807i 1488603967000000000\n",
+		))
+	})
+
+	It("drops values that are not finite", func() {
+		for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+			err := influxEmitter.Emit(factories.NewValueMetric("latency", value, "ms"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(innerEmitter.GetMessages()).To(BeEmpty())
+	})
+
+	It("keeps line breaks in tags from ending the line", func() {
+		err := influxEmitter.EmitEnvelope(&events.Envelope{
+			Origin:      proto.String("router"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			Timestamp:   proto.Int64(timestamp),
+			Tags:        map[string]string{"az": "z1\nfake value=1"},
+			ValueMetric: factories.NewValueMetric("latency\r\n", 1, "ms"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(string(innerEmitter.GetMessages()[0])).To(Equal(
+			`latency\ \ ,az=z1\ fake\ value\=1,origin=router,unit=ms value=1 1488603967000000000` + "\n",
+		))
+	})
+
+	It("drops other events", func() {
+		err := influxEmitter.Emit(factories.NewLogMessage(events.LogMessage_OUT, "hi", "app", "APP"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(innerEmitter.GetMessages()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,49 @@
+package emitter
+
+import (
+	"context"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// lineEmitter writes envelopes to the inner emitter in a line based text
+// protocol. Envelopes for which formatLine returns nil are dropped.
+type lineEmitter struct {
+	innerEmitter ByteEmitter
+	origin       string
+	formatLine   func(*events.Envelope) []byte
+}
+
+func (e *lineEmitter) Origin() string {
+	return e.origin
+}
+
+func (e *lineEmitter) Emit(event events.Event) error {
+	return e.EmitContext(context.Background(), event)
+}
+
+func (e *lineEmitter) EmitContext(ctx context.Context, event events.Event) error {
+	envelope, err := Wrap(event, e.origin)
+	if err != nil {
+		return err
+	}
+
+	return e.EmitEnvelopeContext(ctx, envelope)
+}
+
+func (e *lineEmitter) EmitEnvelope(envelope *events.Envelope) error {
+	return e.EmitEnvelopeContext(context.Background(), envelope)
+}
+
+func (e *lineEmitter) EmitEnvelopeContext(ctx context.Context, envelope *events.Envelope) error {
+	line := e.formatLine(envelope)
+	if line == nil {
+		return nil
+	}
+
+	return emitContext(ctx, e.innerEmitter, line)
+}
+
+func (e *lineEmitter) Close() {
+	e.innerEmitter.Close()
+}
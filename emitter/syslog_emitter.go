@@ -0,0 +1,160 @@
+package emitter
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+const (
+	syslogFacilityUser = 1
+	syslogSeverityErr  = 3
+	syslogSeverityInfo = 6
+
+	syslogMaxHostnameLen = 255
+	syslogMaxAppNameLen  = 48
+	syslogMaxProcIDLen   = 128
+
+	syslogTimestampFormat = "2006-01-02T15:04:05.999999Z07:00"
+)
+
+// EnvelopeEmitter is implemented by anything envelopes can be routed to,
+// such as an EventEmitter.
+type EnvelopeEmitter interface {
+	EmitEnvelope(*events.Envelope) error
+}
+
+type contextEnvelopeEmitter interface {
+	EmitEnvelopeContext(context.Context, *events.Envelope) error
+}
+
+// SyslogEmitter formats LogMessage envelopes as RFC 5424 syslog messages and
+// writes them, framed with octet counting (RFC 6587), to the inner emitter.
+// The application ID becomes the syslog HOSTNAME, the origin the APP-NAME and
+// the source type and instance the PROCID.
+//
+// Envelopes that do not carry a LogMessage are handed to the non-log emitter
+// if one was provided and are dropped otherwise.
+type SyslogEmitter struct {
+	innerEmitter  ByteEmitter
+	nonLogEmitter EnvelopeEmitter
+	origin        string
+}
+
+// NewSyslogEmitter creates a SyslogEmitter writing to byteEmitter, typically
+// a TCPEmitter. nonLogEmitter may be nil.
+func NewSyslogEmitter(byteEmitter ByteEmitter, origin string, nonLogEmitter EnvelopeEmitter) *SyslogEmitter {
+	return &SyslogEmitter{
+		innerEmitter:  byteEmitter,
+		nonLogEmitter: nonLogEmitter,
+		origin:        origin,
+	}
+}
+
+func (e *SyslogEmitter) Origin() string {
+	return e.origin
+}
+
+func (e *SyslogEmitter) Emit(event events.Event) error {
+	return e.EmitContext(context.Background(), event)
+}
+
+func (e *SyslogEmitter) EmitContext(ctx context.Context, event events.Event) error {
+	envelope, err := Wrap(event, e.origin)
+	if err != nil {
+		return err
+	}
+
+	return e.EmitEnvelopeContext(ctx, envelope)
+}
+
+func (e *SyslogEmitter) EmitEnvelope(envelope *events.Envelope) error {
+	return e.EmitEnvelopeContext(context.Background(), envelope)
+}
+
+func (e *SyslogEmitter) EmitEnvelopeContext(ctx context.Context, envelope *events.Envelope) error {
+	if envelope.GetEventType() != events.Envelope_LogMessage || envelope.GetLogMessage() == nil {
+		if e.nonLogEmitter == nil {
+			return nil
+		}
+		if contextEmitter, ok := e.nonLogEmitter.(contextEnvelopeEmitter); ok {
+			return contextEmitter.EmitEnvelopeContext(ctx, envelope)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return e.nonLogEmitter.EmitEnvelope(envelope)
+	}
+
+	return emitContext(ctx, e.innerEmitter, frameOctetCounted(formatRFC5424(envelope)))
+}
+
+func (e *SyslogEmitter) Close() {
+	e.innerEmitter.Close()
+}
+
+func formatRFC5424(envelope *events.Envelope) []byte {
+	logMessage := envelope.GetLogMessage()
+
+	severity := syslogSeverityInfo
+	if logMessage.GetMessageType() == events.LogMessage_ERR {
+		severity = syslogSeverityErr
+	}
+
+	timestamp := logMessage.GetTimestamp()
+	if timestamp == 0 {
+		timestamp = envelope.GetTimestamp()
+	}
+
+	origin := envelope.GetOrigin()
+	procID := "[" + logMessage.GetSourceType() + "/" + logMessage.GetSourceInstance() + "]"
+	if logMessage.GetSourceType() == "" && logMessage.GetSourceInstance() == "" {
+		procID = ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('<')
+	buf.WriteString(strconv.Itoa(syslogFacilityUser*8 + severity))
+	buf.WriteString(">1 ")
+	buf.WriteString(time.Unix(0, timestamp).UTC().Format(syslogTimestampFormat))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(logMessage.GetAppId(), syslogMaxHostnameLen))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(origin, syslogMaxAppNameLen))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(procID, syslogMaxProcIDLen))
+	buf.WriteString(" - - ")
+	buf.Write(bytes.TrimRight(logMessage.GetMessage(), "\r\n"))
+
+	return buf.Bytes()
+}
+
+// syslogHeaderField returns s restricted to the printable US-ASCII characters
+// RFC 5424 allows in header fields, truncated to maxLen. Empty values are
+// replaced by the NILVALUE.
+func syslogHeaderField(s string, maxLen int) string {
+	if s == "" {
+		return "-"
+	}
+
+	field := []byte(s)
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	for i, c := range field {
+		if c < 33 || c > 126 {
+			field[i] = '_'
+		}
+	}
+	return string(field)
+}
+
+func frameOctetCounted(msg []byte) []byte {
+	frame := make([]byte, 0, len(msg)+8)
+	frame = strconv.AppendInt(frame, int64(len(msg)), 10)
+	frame = append(frame, ' ')
+	return append(frame, msg...)
+}
@@ -0,0 +1,122 @@
+package emitter_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SyslogEmitter", func() {
+	var (
+		innerEmitter   *fake.FakeByteEmitter
+		nonLogEmitter  *fake.FakeEventEmitter
+		syslogEmitter  *emitter.SyslogEmitter
+		logTimestamp   time.Time
+		logMessageType events.LogMessage_MessageType
+	)
+
+	var logEnvelope = func(message string) *events.Envelope {
+		return &events.Envelope{
+			Origin:    proto.String("my-origin"),
+			EventType: events.Envelope_LogMessage.Enum(),
+			Timestamp: proto.Int64(time.Now().UnixNano()),
+			LogMessage: &events.LogMessage{
+				Message:        []byte(message),
+				MessageType:    logMessageType.Enum(),
+				Timestamp:      proto.Int64(logTimestamp.UnixNano()),
+				AppId:          proto.String("my-app-id"),
+				SourceType:     proto.String("APP/PROC/WEB"),
+				SourceInstance: proto.String("0"),
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		innerEmitter = fake.NewFakeByteEmitter()
+		nonLogEmitter = fake.NewFakeEventEmitter("my-origin")
+		syslogEmitter = emitter.NewSyslogEmitter(innerEmitter, "my-origin", nonLogEmitter)
+		logTimestamp = time.Date(2017, 3, 4, 5, 6, 7, 123456000, time.UTC)
+		logMessageType = events.LogMessage_OUT
+	})
+
+	It("returns the origin", func() {
+		Expect(syslogEmitter.Origin()).To(Equal("my-origin"))
+	})
+
+	Describe("EmitEnvelope", func() {
+		It("writes an octet counted RFC 5424 message", func() {
+			err := syslogEmitter.EmitEnvelope(logEnvelope("hello world\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			msg := "<14>1 2017-03-04T05:06:07.123456Z my-app-id my-origin [APP/PROC/WEB/0] - - hello world"
+			Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+			Expect(string(innerEmitter.GetMessages()[0])).To(Equal(fmt.Sprintf("%d %s", len(msg), msg)))
+		})
+
+		It("uses the error severity for messages from stderr", func() {
+			logMessageType = events.LogMessage_ERR
+
+			err := syslogEmitter.EmitEnvelope(logEnvelope("oops"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+			Expect(string(innerEmitter.GetMessages()[0])).To(HavePrefix("79 <11>1 "))
+		})
+
+		It("sanitizes header fields", func() {
+			envelope := logEnvelope("hi")
+			envelope.LogMessage.AppId = proto.String("app with spaces")
+			envelope.Origin = proto.String("")
+
+			err := syslogEmitter.EmitEnvelope(envelope)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(string(innerEmitter.GetMessages()[0])).To(ContainSubstring(" app_with_spaces - [APP/PROC/WEB/0] "))
+		})
+
+		It("routes envelopes without a log message to the non-log emitter", func() {
+			envelope, err := emitter.Wrap(factories.NewValueMetric("name", 1, "unit"), "my-origin")
+			Expect(err).ToNot(HaveOccurred())
+
+			err = syslogEmitter.EmitEnvelope(envelope)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(innerEmitter.GetMessages()).To(BeEmpty())
+			Expect(nonLogEmitter.GetEnvelopes()).To(ConsistOf(envelope))
+		})
+
+		It("drops envelopes without a log message without a non-log emitter", func() {
+			syslogEmitter = emitter.NewSyslogEmitter(innerEmitter, "my-origin", nil)
+
+			err := syslogEmitter.Emit(factories.NewValueMetric("name", 1, "unit"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(innerEmitter.GetMessages()).To(BeEmpty())
+		})
+	})
+
+	Describe("Emit", func() {
+		It("wraps the event with the origin", func() {
+			logMessage := logEnvelope("from an event").LogMessage
+
+			err := syslogEmitter.Emit(logMessage)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(innerEmitter.GetMessages()).To(HaveLen(1))
+			Expect(string(innerEmitter.GetMessages()[0])).To(ContainSubstring(" my-app-id my-origin [APP/PROC/WEB/0] - - from an event"))
+		})
+	})
+
+	It("closes the inner emitter", func() {
+		syslogEmitter.Close()
+		Expect(innerEmitter.IsClosed()).To(BeTrue())
+	})
+})
@@ -0,0 +1,195 @@
+package emitter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	defaultDialTimeout  = 5 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// TCPEmitter writes data to a stream connection. The connection is dialed
+// lazily and redialed whenever a write fails, so a restarted receiver is
+// picked up without restarting the emitting process.
+type TCPEmitter struct {
+	remoteAddr   string
+	tlsConfig    *tls.Config
+	dialer       *net.Dialer
+	writeTimeout time.Duration
+
+	// sem guards conn and closed. It is a channel rather than a mutex so
+	// that waiting for it can be abandoned when a context is done.
+	sem    chan struct{}
+	conn   net.Conn
+	closed bool
+}
+
+// TCPOption configures a TCPEmitter.
+type TCPOption func(*TCPEmitter)
+
+// WithWriteTimeout sets how long a write may take when the context it is
+// made with has no deadline, as with Emit. It defaults to 10 seconds, so
+// that a receiver that stops reading cannot block the emitter forever. A
+// timeout of zero disables it.
+func WithWriteTimeout(timeout time.Duration) TCPOption {
+	return func(e *TCPEmitter) {
+		e.writeTimeout = timeout
+	}
+}
+
+// NewTcpEmitter creates a TCPEmitter that writes to remoteAddr in plain text.
+func NewTcpEmitter(remoteAddr string, opts ...TCPOption) (*TCPEmitter, error) {
+	return newTCPEmitter(remoteAddr, nil, opts)
+}
+
+// NewTlsEmitter creates a TCPEmitter that writes to remoteAddr over TLS using
+// the given configuration.
+func NewTlsEmitter(remoteAddr string, tlsConfig *tls.Config, opts ...TCPOption) (*TCPEmitter, error) {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return newTCPEmitter(remoteAddr, tlsConfig, opts)
+}
+
+func newTCPEmitter(remoteAddr string, tlsConfig *tls.Config, opts []TCPOption) (*TCPEmitter, error) {
+	if _, _, err := net.SplitHostPort(remoteAddr); err != nil {
+		return nil, err
+	}
+
+	e := &TCPEmitter{
+		remoteAddr:   remoteAddr,
+		tlsConfig:    tlsConfig,
+		dialer:       &net.Dialer{Timeout: defaultDialTimeout},
+		writeTimeout: defaultWriteTimeout,
+		sem:          make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
+}
+
+// Emit writes data to the connection. If there is no connection, or the
+// write on the current connection fails, the connection is redialed and the
+// write retried once.
+func (e *TCPEmitter) Emit(data []byte) error {
+	return e.EmitContext(context.Background(), data)
+}
+
+// EmitContext is like Emit, but waiting for other writers, dialing and
+// writing are all abandoned once ctx is done.
+func (e *TCPEmitter) EmitContext(ctx context.Context, data []byte) error {
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e.sem }()
+
+	if e.closed {
+		return net.ErrClosed
+	}
+
+	if e.conn != nil {
+		if err := e.write(ctx, data); err == nil {
+			return nil
+		}
+		e.resetConn()
+	}
+
+	if err := e.dial(ctx); err != nil {
+		return err
+	}
+
+	if err := e.write(ctx, data); err != nil {
+		e.resetConn()
+		return err
+	}
+	return nil
+}
+
+// NonRetaining marks TCPEmitter as a NonRetainingByteEmitter.
+func (e *TCPEmitter) NonRetaining() {}
+
+// Close closes the current connection. Subsequent calls to Emit return an
+// error.
+func (e *TCPEmitter) Close() {
+	e.sem <- struct{}{}
+	defer func() { <-e.sem }()
+
+	e.closed = true
+	e.resetConn()
+}
+
+func (e *TCPEmitter) dial(ctx context.Context) error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if e.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: e.dialer, Config: e.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", e.remoteAddr)
+	} else {
+		conn, err = e.dialer.DialContext(ctx, "tcp", e.remoteAddr)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.conn = conn
+	return nil
+}
+
+func (e *TCPEmitter) write(ctx context.Context, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok && e.writeTimeout > 0 {
+		deadline = time.Now().Add(e.writeTimeout)
+	}
+	e.conn.SetWriteDeadline(deadline)
+
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		exited := make(chan struct{})
+		defer func() {
+			close(stop)
+			<-exited
+		}()
+		conn := e.conn
+		go func() {
+			defer close(exited)
+			select {
+			case <-ctx.Done():
+				conn.SetWriteDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+	}
+
+	_, err := e.conn.Write(data)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The connection can reach the context's deadline before the
+		// context itself notices.
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (e *TCPEmitter) resetConn() {
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+}
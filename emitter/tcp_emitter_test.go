@@ -0,0 +1,201 @@
+package emitter_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TcpEmitter", func() {
+	var (
+		listener net.Listener
+		received chan []byte
+	)
+
+	var acceptAndRead = func() {
+		listener, received := listener, received
+		go func() {
+			defer GinkgoRecover()
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					buffer := make([]byte, 4096)
+					for {
+						n, err := conn.Read(buffer)
+						if n > 0 {
+							data := make([]byte, n)
+							copy(data, buffer[:n])
+							received <- data
+						}
+						if err != nil {
+							return
+						}
+					}
+				}()
+			}
+		}()
+	}
+
+	BeforeEach(func() {
+		received = make(chan []byte, 100)
+	})
+
+	Describe("NewTcpEmitter()", func() {
+		It("returns an error for an invalid address", func() {
+			tcpEmitter, err := emitter.NewTcpEmitter("invalid-address")
+			Expect(tcpEmitter).To(BeNil())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Emit()", func() {
+		Context("with a plain text listener", func() {
+			var tcpEmitter *emitter.TCPEmitter
+
+			BeforeEach(func() {
+				var err error
+				listener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).ToNot(HaveOccurred())
+				acceptAndRead()
+
+				tcpEmitter, err = emitter.NewTcpEmitter(listener.Addr().String())
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				tcpEmitter.Close()
+				listener.Close()
+			})
+
+			It("sends the data", func() {
+				Expect(tcpEmitter.Emit([]byte("hello"))).To(Succeed())
+				Eventually(received).Should(Receive(Equal([]byte("hello"))))
+			})
+
+			It("returns an error after it is closed", func() {
+				tcpEmitter.Close()
+				Expect(tcpEmitter.Emit([]byte("hello"))).ToNot(Succeed())
+			})
+		})
+
+		Context("when the receiver stops reading", func() {
+			It("gives up writing once the context deadline passes", func() {
+				var err error
+				listener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).ToNot(HaveOccurred())
+				defer listener.Close()
+
+				tcpEmitter, err := emitter.NewTcpEmitter(listener.Addr().String())
+				Expect(err).ToNot(HaveOccurred())
+				defer tcpEmitter.Close()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				start := time.Now()
+				err = tcpEmitter.EmitContext(ctx, make([]byte, 64*1024*1024))
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			})
+
+			It("gives up writing once the write timeout passes without a context deadline", func() {
+				var err error
+				listener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).ToNot(HaveOccurred())
+				defer listener.Close()
+
+				tcpEmitter, err := emitter.NewTcpEmitter(listener.Addr().String(), emitter.WithWriteTimeout(100*time.Millisecond))
+				Expect(err).ToNot(HaveOccurred())
+				defer tcpEmitter.Close()
+
+				start := time.Now()
+				err = tcpEmitter.Emit(make([]byte, 64*1024*1024))
+				Expect(err).To(HaveOccurred())
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+				closed := make(chan struct{})
+				go func() {
+					tcpEmitter.Close()
+					close(closed)
+				}()
+				Eventually(closed).Should(BeClosed())
+			})
+		})
+
+		Context("when the receiver is not listening", func() {
+			It("returns an error and reconnects once the receiver is back", func() {
+				var err error
+				listener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).ToNot(HaveOccurred())
+				addr := listener.Addr().String()
+				listener.Close()
+
+				tcpEmitter, err := emitter.NewTcpEmitter(addr)
+				Expect(err).ToNot(HaveOccurred())
+				defer tcpEmitter.Close()
+
+				Expect(tcpEmitter.Emit([]byte("lost"))).ToNot(Succeed())
+
+				listener, err = net.Listen("tcp", addr)
+				Expect(err).ToNot(HaveOccurred())
+				defer listener.Close()
+				acceptAndRead()
+
+				Expect(tcpEmitter.Emit([]byte("hello"))).To(Succeed())
+				Eventually(received).Should(Receive(Equal([]byte("hello"))))
+			})
+		})
+
+		Context("with a TLS listener", func() {
+			It("sends the data over TLS", func() {
+				var err error
+				listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+					Certificates: []tls.Certificate{selfSignedCert()},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				defer listener.Close()
+				acceptAndRead()
+
+				tlsEmitter, err := emitter.NewTlsEmitter(listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+				Expect(err).ToNot(HaveOccurred())
+				defer tlsEmitter.Close()
+
+				Expect(tlsEmitter.Emit([]byte("secret"))).To(Succeed())
+				Eventually(received).Should(Receive(Equal([]byte("secret"))))
+			})
+		})
+	})
+})
+
+func selfSignedCert() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
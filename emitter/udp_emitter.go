@@ -2,11 +2,12 @@ package emitter
 
 import (
 	"net"
+	"net/netip"
 )
 
 type UDPEmitter struct {
-	udpAddr *net.UDPAddr
-	udpConn net.PacketConn
+	udpAddr netip.AddrPort
+	udpConn *net.UDPConn
 }
 
 func NewUdpEmitter(remoteAddr string) (*UDPEmitter, error) {
@@ -15,20 +16,34 @@ func NewUdpEmitter(remoteAddr string) (*UDPEmitter, error) {
 		return nil, err
 	}
 
-	conn, err := net.ListenPacket("udp4", "")
+	conn, err := net.ListenUDP("udp4", nil)
 	if err != nil {
 		return nil, err
 	}
 
-	emitter := &UDPEmitter{udpAddr: addr, udpConn: conn}
+	// The address is kept as a netip.AddrPort since writing to one does not
+	// allocate, unlike writing to a *net.UDPAddr.
+	addrPort := addr.AddrPort()
+	emitter := &UDPEmitter{
+		udpAddr: netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()),
+		udpConn: conn,
+	}
 	return emitter, nil
 }
 
 func (e *UDPEmitter) Emit(data []byte) error {
-	_, err := e.udpConn.WriteTo(data, e.udpAddr)
+	_, err := e.udpConn.WriteToUDPAddrPort(data, e.udpAddr)
 	return err
 }
 
+// NonRetaining marks UDPEmitter as a NonRetainingByteEmitter.
+func (e *UDPEmitter) NonRetaining() {}
+
+// MaxPayloadSize returns the largest payload a single datagram can carry.
+func (e *UDPEmitter) MaxPayloadSize() int {
+	return MaxUDPPayloadSize
+}
+
 func (e *UDPEmitter) Close() {
 	e.udpConn.Close()
 }
@@ -0,0 +1,244 @@
+// Package expvar_bridge periodically publishes the variables exported with
+// the expvar package as dropsonde metrics.
+//
+// Use
+//
+//	bridge := expvar_bridge.New(sender, 10*time.Second, expvar_bridge.WithAllow("myapp.*"))
+//	go bridge.Run(stopChan)
+//
+// Nested variables, such as the entries of an *expvar.Map or the fields of a
+// JSON object published with expvar.Func, are flattened into dotted names:
+// the "hits" entry of the map "cache" is sent as "cache.hits". Numeric
+// leaves are sent as ValueMetrics, except for *expvar.Int variables whose
+// names match a pattern given to WithCounters, which are sent as
+// CounterEvents with the change since the previous collection. Strings,
+// booleans and arrays are skipped.
+package expvar_bridge
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/units"
+)
+
+// DefaultDeny are the variables skipped unless WithDeny is given. The
+// variables published by the expvar package itself are skipped: cmdline is
+// not numeric, and reading memstats stops the world, while package
+// runtime_stats reports the same statistics without doing so.
+var DefaultDeny = []string{"cmdline", "memstats"}
+
+type MetricSender interface {
+	Value(name string, value float64, unit string) metric_sender.ValueChainer
+	Counter(name string) metric_sender.CounterChainer
+}
+
+// An Option configures a Bridge created by New.
+type Option func(*Bridge)
+
+// WithAllow only publishes the leaves whose flattened names match one of the
+// given patterns. Patterns use the syntax of path.Match, in which * also
+// matches dots.
+func WithAllow(patterns ...string) Option {
+	return func(b *Bridge) {
+		b.allow = patterns
+	}
+}
+
+// WithDeny skips the variables and leaves whose names match one of the given
+// patterns, in place of DefaultDeny. Patterns use the syntax of path.Match.
+func WithDeny(patterns ...string) Option {
+	return func(b *Bridge) {
+		b.deny = patterns
+	}
+}
+
+// WithCounters sends the *expvar.Int leaves whose flattened names match one of
+// the given patterns as counters rather than values. Patterns use the syntax
+// of path.Match. The first collection of a counter only records its value,
+// and later ones send the change since the previous collection. A decrease
+// is treated as a reset of the counter, after which its whole value is sent.
+func WithCounters(patterns ...string) Option {
+	return func(b *Bridge) {
+		b.counterPatterns = patterns
+	}
+}
+
+// WithPrefix prepends prefix to the name of every metric.
+func WithPrefix(prefix string) Option {
+	return func(b *Bridge) {
+		b.prefix = prefix
+	}
+}
+
+// A Bridge publishes expvar variables as metrics.
+type Bridge struct {
+	sender          MetricSender
+	interval        time.Duration
+	allow           []string
+	deny            []string
+	counterPatterns []string
+	prefix          string
+
+	// counters holds the value of each counter at the previous collection.
+	counters map[string]int64
+}
+
+// New creates a Bridge that publishes expvar variables through sender every
+// interval.
+func New(sender MetricSender, interval time.Duration, opts ...Option) *Bridge {
+	b := &Bridge{
+		sender:   sender,
+		interval: interval,
+		deny:     DefaultDeny,
+		counters: make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Run publishes the variables every interval until stopChan is closed.
+// Errors are logged.
+func (b *Bridge) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Collect(); err != nil {
+			log.Printf("ExpvarBridge: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// Collect publishes the current value of every variable. It returns the
+// first error that occurred, after attempting to publish every variable.
+func (b *Bridge) Collect() error {
+	c := collection{bridge: b}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !b.denied(kv.Key) {
+			c.walk(kv.Key, kv.Value)
+		}
+	})
+	return c.err
+}
+
+func (b *Bridge) denied(name string) bool {
+	return matchAny(b.deny, name)
+}
+
+func (b *Bridge) allowed(name string) bool {
+	if b.denied(name) {
+		return false
+	}
+	return len(b.allow) == 0 || matchAny(b.allow, name)
+}
+
+type collection struct {
+	bridge *Bridge
+	err    error
+}
+
+func (c *collection) walk(name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		if matchAny(c.bridge.counterPatterns, name) {
+			c.counter(name, v.Value())
+		} else {
+			c.value(name, float64(v.Value()))
+		}
+	case *expvar.Float:
+		c.value(name, v.Value())
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			c.walk(name+"."+kv.Key, kv.Value)
+		})
+	default:
+		c.walkJSON(name, v.String())
+	}
+}
+
+// walkJSON publishes the numeric leaves of a variable of another type, from
+// its JSON representation.
+func (c *collection) walkJSON(name, data string) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		c.record(fmt.Errorf("failed to decode %s: %v", name, err))
+		return
+	}
+	c.walkDecoded(name, value)
+}
+
+func (c *collection) walkDecoded(name string, value interface{}) {
+	switch value := value.(type) {
+	case json.Number:
+		f, err := value.Float64()
+		if err == nil {
+			c.value(name, f)
+		}
+	case map[string]interface{}:
+		for k, v := range value {
+			c.walkDecoded(name+"."+k, v)
+		}
+	}
+}
+
+func (c *collection) value(name string, value float64) {
+	if !c.bridge.allowed(name) {
+		return
+	}
+	c.record(c.bridge.sender.Value(c.bridge.prefix+name, value, units.Count).Send())
+}
+
+// counter sends the change of an *expvar.Int since the previous collection.
+// The first collection only records the value. A decrease is treated as a
+// reset, after which the whole value is sent.
+func (c *collection) counter(name string, value int64) {
+	if !c.bridge.allowed(name) {
+		return
+	}
+
+	previous, seen := c.bridge.counters[name]
+	c.bridge.counters[name] = value
+	if !seen {
+		return
+	}
+	delta := value - previous
+	if value < previous {
+		delta = value
+	}
+	if delta <= 0 {
+		return
+	}
+	c.record(c.bridge.sender.Counter(c.bridge.prefix + name).Add(uint64(delta)))
+}
+
+func (c *collection) record(err error) {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
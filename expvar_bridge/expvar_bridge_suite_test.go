@@ -0,0 +1,13 @@
+package expvar_bridge_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestExpvarBridge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ExpvarBridge Suite")
+}
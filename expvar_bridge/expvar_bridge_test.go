@@ -0,0 +1,168 @@
+package expvar_bridge_test
+
+import (
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/expvar_bridge"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var (
+	requests = expvar.NewInt("bridge.requests")
+	load     = expvar.NewFloat("bridge.load")
+	cache    = expvar.NewMap("bridge.cache")
+	secret   = expvar.NewInt("bridge.secret")
+)
+
+func init() {
+	expvar.Publish("bridge.info", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"queue":   map[string]interface{}{"depth": 3, "name": "jobs"},
+			"healthy": true,
+			"workers": []int{1, 2},
+		}
+	}))
+}
+
+var _ = Describe("Bridge", func() {
+	var (
+		fakeEmitter *fake.FakeEventEmitter
+		bridge      *expvar_bridge.Bridge
+	)
+
+	var values = func() map[string]float64 {
+		values := make(map[string]float64)
+		for _, envelope := range fakeEmitter.GetEnvelopes() {
+			if metric := envelope.GetValueMetric(); metric != nil {
+				values[metric.GetName()] = metric.GetValue()
+			}
+		}
+		return values
+	}
+
+	var counters = func() map[string][]uint64 {
+		counters := make(map[string][]uint64)
+		for _, envelope := range fakeEmitter.GetEnvelopes() {
+			if counter := envelope.GetCounterEvent(); counter != nil {
+				counters[counter.GetName()] = append(counters[counter.GetName()], counter.GetDelta())
+			}
+		}
+		return counters
+	}
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		requests.Set(5)
+		load.Set(0.75)
+		cache.Init()
+		cache.Add("hits", 10)
+		cache.AddFloat("ratio", 0.5)
+		secret.Set(42)
+		bridge = expvar_bridge.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour, expvar_bridge.WithAllow("bridge.*"))
+	})
+
+	It("sends numeric leaves as values under flattened names", func() {
+		Expect(bridge.Collect()).To(Succeed())
+
+		Expect(values()).To(Equal(map[string]float64{
+			"bridge.requests":         5,
+			"bridge.secret":           42,
+			"bridge.load":             0.75,
+			"bridge.cache.hits":       10,
+			"bridge.cache.ratio":      0.5,
+			"bridge.info.queue.depth": 3,
+		}))
+		Expect(counters()).To(BeEmpty())
+	})
+
+	It("sends integers that go down as their current value", func() {
+		Expect(bridge.Collect()).To(Succeed())
+		requests.Set(2)
+		fakeEmitter.Reset()
+		Expect(bridge.Collect()).To(Succeed())
+
+		Expect(values()).To(HaveKeyWithValue("bridge.requests", 2.0))
+	})
+
+	It("sends integers that match a counter pattern as counter deltas", func() {
+		bridge = expvar_bridge.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour,
+			expvar_bridge.WithAllow("bridge.*"),
+			expvar_bridge.WithCounters("bridge.requests", "bridge.cache.*"),
+		)
+
+		Expect(bridge.Collect()).To(Succeed())
+		Expect(counters()).To(BeEmpty())
+
+		requests.Add(3)
+		cache.Add("hits", 4)
+		Expect(bridge.Collect()).To(Succeed())
+		Expect(bridge.Collect()).To(Succeed())
+		requests.Set(2)
+		Expect(bridge.Collect()).To(Succeed())
+
+		Expect(counters()).To(Equal(map[string][]uint64{
+			"bridge.requests":   {3, 2},
+			"bridge.cache.hits": {4},
+		}))
+		Expect(values()).ToNot(HaveKey("bridge.requests"))
+		Expect(values()).To(HaveKey("bridge.secret"))
+	})
+
+	It("skips variables that match a deny pattern", func() {
+		bridge = expvar_bridge.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour,
+			expvar_bridge.WithAllow("bridge.*"),
+			expvar_bridge.WithDeny("bridge.secret", "bridge.cache.*"),
+		)
+		Expect(bridge.Collect()).To(Succeed())
+
+		Expect(values()).To(HaveKey("bridge.requests"))
+		Expect(values()).ToNot(HaveKey("bridge.secret"))
+		Expect(values()).ToNot(HaveKey("bridge.cache.hits"))
+		Expect(values()).ToNot(HaveKey("bridge.cache.ratio"))
+	})
+
+	It("skips the variables of the expvar package by default", func() {
+		bridge = expvar_bridge.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour)
+		Expect(bridge.Collect()).To(Succeed())
+
+		Expect(values()).To(HaveKey("bridge.load"))
+		for name := range values() {
+			Expect(name).ToNot(HavePrefix("memstats"))
+		}
+	})
+
+	It("prefixes names", func() {
+		bridge = expvar_bridge.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour,
+			expvar_bridge.WithAllow("bridge.load"),
+			expvar_bridge.WithPrefix("expvar."),
+		)
+		Expect(bridge.Collect()).To(Succeed())
+
+		Expect(values()).To(Equal(map[string]float64{"expvar.bridge.load": 0.75}))
+	})
+
+	It("returns send errors", func() {
+		fakeEmitter.ReturnError = errors.New("send failed")
+
+		Expect(bridge.Collect()).To(MatchError("send failed"))
+	})
+
+	It("publishes on an interval", func() {
+		bridge = expvar_bridge.New(metric_sender.NewMetricSender(fakeEmitter), 10*time.Millisecond, expvar_bridge.WithAllow("bridge.load"))
+		stopChan, runDone := make(chan struct{}), make(chan struct{})
+		go func() {
+			bridge.Run(stopChan)
+			close(runDone)
+		}()
+
+		Eventually(func() int { return len(fakeEmitter.GetEnvelopes()) }).Should(BeNumerically(">=", 2))
+		close(stopChan)
+		Eventually(runDone).Should(BeClosed())
+	})
+})
@@ -1,6 +1,6 @@
 module github.com/cloudfoundry/dropsonde
 
-go 1.18
+go 1.21
 
 require (
 	github.com/apoydence/eachers v0.0.0-20181020210610-23942921fe77
@@ -45,18 +45,19 @@ var _ = Describe("LogIntegration", func() {
 			udpConn.Close()
 		})
 
-		It("sends dropped error message for messages which are just under 64k and don't fit in UDP packet", func() {
+		It("splits messages which are just under 64k and don't fit in a UDP packet", func() {
 			logSender := log_sender.NewLogSender(dropsonde.AutowiredEmitter())
 
 			const length = 64*1024 - 1
 			reader := strings.NewReader(strings.Repeat("s", length) + "\n")
 			logSender.ScanErrorLogStream("someId", "app", "0", reader)
 
-			Eventually(logMessages.Length).Should(Equal(1))
+			Eventually(logMessages.Length).Should(Equal(2))
 
-			msg := logMessages.Get(0)
-			Expect(msg.MessageType).To(Equal(events.LogMessage_ERR.Enum()))
-			Expect(string(msg.GetMessage())).To(ContainSubstring("message could not fit in UDP packet"))
+			for i := 0; i < 2; i++ {
+				Expect(logMessages.Get(i).MessageType).To(Equal(events.LogMessage_ERR.Enum()))
+			}
+			Expect(len(logMessages.Get(0).GetMessage()) + len(logMessages.Get(1).GetMessage())).To(Equal(length))
 		})
 
 		It("sends dropped error message for messages which are over 64k", func() {
@@ -101,7 +102,7 @@ func (logs *LogMessages) Get(i int) *events.LogMessage {
 
 func listenForLogs(udpConn net.PacketConn, logMessages *LogMessages) {
 	for {
-		buffer := make([]byte, 1024)
+		buffer := make([]byte, 64*1024)
 		n, _, err := udpConn.ReadFrom(buffer)
 		if err != nil {
 			return
@@ -2,14 +2,19 @@ package log_sender
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"io"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
 	"fmt"
 	"syscall"
 
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
 	"github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/sonde-go/events"
 	"google.golang.org/protobuf/proto"
@@ -35,9 +40,17 @@ type LogChainer interface {
 	Send() error
 }
 
+// ContextLogChainer is a LogChainer that can also be sent with a context. The
+// LogChainers of a LogSender implement it.
+type ContextLogChainer interface {
+	LogChainer
+	SendContext(ctx context.Context) error
+}
+
 // A LogSender emits log events.
 type LogSender struct {
 	eventEmitter EventEmitter
+	defaultTags  atomic.Value
 }
 
 // NewLogSender instantiates a LogSender with the given EventEmitter.
@@ -47,20 +60,50 @@ func NewLogSender(eventEmitter EventEmitter) *LogSender {
 	}
 }
 
+// SetDefaultTags sets tags that are added to every log message sent. Tags set
+// on a LogChainer take precedence, and the limit on the number of tags
+// applies to the merged set. It returns an error, and leaves the default
+// tags unchanged, if the tags exceed the limits on tags.
+func (l *LogSender) SetDefaultTags(tags map[string]string) error {
+	if err := metric_sender.ValidateTags(tags); err != nil {
+		return err
+	}
+
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	l.defaultTags.Store(copied)
+	return nil
+}
+
 // SendAppLog sends a log message with the given appid and log message
 // with a message type of std out.
 // Returns an error if one occurs while sending the event.
 func (l *LogSender) SendAppLog(appID, message, sourceType, sourceInstance string) error {
-	metrics.BatchIncrementCounter("logSenderTotalMessagesRead")
-	return l.eventEmitter.Emit(makeLogMessage(appID, message, sourceType, sourceInstance, events.LogMessage_OUT))
+	return l.sendAppLog(appID, message, sourceType, sourceInstance, events.LogMessage_OUT)
 }
 
 // SendAppErrorLog sends a log error message with the given appid and log message
 // with a message type of std err.
 // Returns an error if one occurs while sending the event.
 func (l *LogSender) SendAppErrorLog(appID, message, sourceType, sourceInstance string) error {
+	return l.sendAppLog(appID, message, sourceType, sourceInstance, events.LogMessage_ERR)
+}
+
+// sendAppLog emits the log message as a bare event, unless default tags are
+// set, which only an envelope can carry.
+func (l *LogSender) sendAppLog(appID, message, sourceType, sourceInstance string, messageType events.LogMessage_MessageType) error {
+	if l.copyDefaultTags() != nil {
+		return l.LogMessage([]byte(message), messageType).
+			SetAppId(appID).
+			SetSourceType(sourceType).
+			SetSourceInstance(sourceInstance).
+			Send()
+	}
+
 	metrics.BatchIncrementCounter("logSenderTotalMessagesRead")
-	return l.eventEmitter.Emit(makeLogMessage(appID, message, sourceType, sourceInstance, events.LogMessage_ERR))
+	return l.eventEmitter.Emit(makeLogMessage(appID, message, sourceType, sourceInstance, messageType))
 }
 
 // ScanLogStream sends a log message with the given meta-data for each line from reader.
@@ -83,6 +126,7 @@ func (l *LogSender) LogMessage(message []byte, msgType events.LogMessage_Message
 		envelope: &events.Envelope{
 			Origin:    proto.String(l.eventEmitter.Origin()),
 			EventType: events.Envelope_LogMessage.Enum(),
+			Tags:      l.copyDefaultTags(),
 			LogMessage: &events.LogMessage{
 				Message:     message,
 				MessageType: msgType.Enum(),
@@ -91,6 +135,21 @@ func (l *LogSender) LogMessage(message []byte, msgType events.LogMessage_Message
 	}
 }
 
+// copyDefaultTags returns a copy of the default tags for a new envelope, or
+// nil if there are none.
+func (l *LogSender) copyDefaultTags() map[string]string {
+	defaults, _ := l.defaultTags.Load().(map[string]string)
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	tags := make(map[string]string, len(defaults))
+	for k, v := range defaults {
+		tags[k] = v
+	}
+	return tags
+}
+
 func (l *LogSender) scanLogStream(appID, sourceType, sourceInstance string, sender func(string, string, string, string) error, reader io.Reader) {
 	for {
 		err := sendScannedLines(appID, sourceType, sourceInstance, bufio.NewScanner(reader), sender)
@@ -112,6 +171,12 @@ func (l *LogSender) isMessageTooLong(err error, appID string, sourceType string,
 		return true
 	}
 
+	var tooLarge *emitter.EnvelopeTooLargeError
+	if errors.As(err, &tooLarge) {
+		l.SendAppErrorLog(appID, "Dropped log message: message exceeds transport size limit", sourceType, sourceInstance)
+		return true
+	}
+
 	if strings.Contains(err.Error(), syscall.EMSGSIZE.Error()) {
 		l.SendAppErrorLog(appID, fmt.Sprintf("Dropped log message: message could not fit in UDP packet"), sourceType, sourceInstance)
 		return true
@@ -151,6 +216,10 @@ type envelopeEmitter interface {
 	EmitEnvelope(*events.Envelope) error
 }
 
+type contextEnvelopeEmitter interface {
+	EmitEnvelopeContext(context.Context, *events.Envelope) error
+}
+
 type logChainer struct {
 	emitter  envelopeEmitter
 	envelope *events.Envelope
@@ -199,6 +268,13 @@ func (c logChainer) SetSourceInstance(s string) LogChainer {
 // Send sends the log message with the envelope timestamp set to now and the
 // log message timestamp set to now if none was provided by SetTimestamp.
 func (c logChainer) Send() error {
+	return c.SendContext(context.Background())
+}
+
+// SendContext is like Send, but the emitter gives up once ctx is done.
+// Emitters that do not accept a context are only called if ctx is not yet
+// done.
+func (c logChainer) SendContext(ctx context.Context) error {
 	if c.err != nil {
 		return c.err
 	}
@@ -210,5 +286,12 @@ func (c logChainer) Send() error {
 	if c.envelope.LogMessage.Timestamp == nil {
 		c.envelope.LogMessage.Timestamp = proto.Int64(time.Now().UnixNano())
 	}
+
+	if emitter, ok := c.emitter.(contextEnvelopeEmitter); ok {
+		return emitter.EmitEnvelopeContext(ctx, c.envelope)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return c.emitter.EmitEnvelope(c.envelope)
 }
@@ -2,15 +2,19 @@ package log_sender_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"syscall"
+	"testing/iotest"
 	"time"
 
 	. "github.com/apoydence/eachers"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	dropsonde_emitter "github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	"github.com/cloudfoundry/dropsonde/log_sender"
 	"github.com/cloudfoundry/dropsonde/metrics"
@@ -193,6 +197,27 @@ var _ = Describe("LogSender", func() {
 				With("logSenderTotalMessagesRead"),
 			))
 		})
+
+		Context("SendContext", func() {
+			It("sends the log message", func() {
+				chainer := sender.LogMessage([]byte("with-context"), events.LogMessage_OUT).SetAppId("app-id")
+				err := chainer.(log_sender.ContextLogChainer).SendContext(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+				Expect(emitter.GetEnvelopes()[0].LogMessage.GetMessage()).To(Equal([]byte("with-context")))
+			})
+
+			It("does not send once the context is done", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				chainer := sender.LogMessage([]byte("with-context"), events.LogMessage_OUT)
+				err := chainer.(log_sender.ContextLogChainer).SendContext(ctx)
+				Expect(err).To(MatchError(context.Canceled))
+				Expect(emitter.GetEnvelopes()).To(BeEmpty())
+			})
+		})
 	})
 
 	Describe("SendAppLog", func() {
@@ -263,6 +288,47 @@ var _ = Describe("LogSender", func() {
 		})
 	})
 
+	Describe("SetDefaultTags", func() {
+		BeforeEach(func() {
+			Expect(sender.SetDefaultTags(map[string]string{"az": "z1"})).To(Succeed())
+		})
+
+		It("adds them to log messages", func() {
+			Expect(sender.LogMessage([]byte("message"), events.LogMessage_OUT).SetTag("az", "z2").SetTag("route", "/").Send()).To(Succeed())
+			Expect(sender.LogMessage([]byte("message"), events.LogMessage_OUT).Send()).To(Succeed())
+
+			Expect(emitter.GetEnvelopes()).To(HaveLen(2))
+			Expect(emitter.GetEnvelopes()[0].GetTags()).To(Equal(map[string]string{"az": "z2", "route": "/"}))
+			Expect(emitter.GetEnvelopes()[1].GetTags()).To(Equal(map[string]string{"az": "z1"}))
+		})
+
+		It("adds them to app logs", func() {
+			Expect(sender.SendAppLog("app-id", "message", "App", "0")).To(Succeed())
+			Expect(sender.SendAppErrorLog("app-id", "error", "App", "0")).To(Succeed())
+
+			Expect(emitter.GetEnvelopes()).To(HaveLen(2))
+			for _, envelope := range emitter.GetEnvelopes() {
+				Expect(envelope.GetTags()).To(HaveKeyWithValue("az", "z1"))
+				Expect(envelope.GetLogMessage().GetAppId()).To(Equal("app-id"))
+				Expect(envelope.GetLogMessage().GetSourceType()).To(Equal("App"))
+				Expect(envelope.GetLogMessage().GetSourceInstance()).To(Equal("0"))
+			}
+			Expect(emitter.GetEnvelopes()[1].GetLogMessage().GetMessageType()).To(Equal(events.LogMessage_ERR))
+			Eventually(mockBatcher.BatchIncrementCounterInput).Should(BeCalled(
+				With("logSenderTotalMessagesRead"),
+				With("logSenderTotalMessagesRead"),
+			))
+		})
+
+		It("rejects too many tags", func() {
+			tags := make(map[string]string)
+			for i := 0; i < 11; i++ {
+				tags[fmt.Sprintf("key-%d", i)] = "value"
+			}
+			Expect(sender.SetDefaultTags(tags)).To(MatchError("Too many tags. Max of 10"))
+		})
+	})
+
 	Describe("ScanLogStream", func() {
 		It("sends lines from stream to emitter", func() {
 			buf := bytes.NewBufferString("line 1\nline 2\n")
@@ -338,6 +404,34 @@ var _ = Describe("LogSender", func() {
 			Expect(messages[2]).To(Equal("small message"))
 		})
 
+		It("drops messages that are too large for the transport and resumes scanning", func() {
+			emitter.ReturnError = &dropsonde_emitter.EnvelopeTooLargeError{
+				EventType: events.Envelope_LogMessage,
+				Size:      70000,
+				MaxSize:   dropsonde_emitter.MaxUDPPayloadSize,
+			}
+			reader := iotest.OneByteReader(strings.NewReader("too large\nsmall message\n"))
+
+			sender.ScanLogStream("someId", "app", "0", reader)
+
+			messages := getLogMessages(emitter.GetMessages())
+			Expect(messages).To(HaveLen(2))
+			Expect(messages[0]).To(Equal("Dropped log message: message exceeds transport size limit"))
+			Expect(messages[1]).To(Equal("small message"))
+		})
+
+		It("drops messages that are too large for a UDP packet and resumes scanning", func() {
+			emitter.ReturnError = syscall.EMSGSIZE
+			reader := iotest.OneByteReader(strings.NewReader("too large\nsmall message\n"))
+
+			sender.ScanLogStream("someId", "app", "0", reader)
+
+			messages := getLogMessages(emitter.GetMessages())
+			Expect(messages).To(HaveLen(2))
+			Expect(messages[0]).To(Equal("Dropped log message: message could not fit in UDP packet"))
+			Expect(messages[1]).To(Equal("small message"))
+		})
+
 		It("ignores empty lines", func() {
 			reader := strings.NewReader("one\n\ntwo\n")
 
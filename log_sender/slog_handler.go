@@ -0,0 +1,196 @@
+package log_sender
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// HandlerOptions configure a Handler created by NewHandler.
+type HandlerOptions struct {
+	// Level is the minimum level of the records that are sent. It defaults
+	// to slog.LevelInfo.
+	Level slog.Leveler
+	// ErrorLevel is the minimum level of the records that are sent as ERR
+	// log messages. Other records are sent as OUT. It defaults to
+	// slog.LevelError.
+	ErrorLevel slog.Leveler
+	// JSON renders records as JSON objects, as slog.JSONHandler does,
+	// rather than as key=value pairs, as slog.TextHandler does.
+	JSON bool
+}
+
+// A Handler is an slog.Handler that sends every record as a log message.
+// The message is the record rendered without its time, which is sent as the
+// timestamp of the log message. Attributes are also set as tags, keyed by
+// their name qualified with the names of the groups they are in, separated
+// by dots. Attributes that would exceed the limits on tags are only
+// rendered in the message.
+type Handler struct {
+	sender                            *LogSender
+	appID, sourceType, sourceInstance string
+	level, errorLevel                 slog.Leveler
+
+	// inner renders records into buf, which is shared by every handler
+	// derived from the same NewHandler call and guarded by lock.
+	inner slog.Handler
+	buf   *bytes.Buffer
+	lock  *sync.Mutex
+
+	tags   [][2]string
+	groups []string
+}
+
+// NewHandler creates a Handler that sends records as log messages of the
+// given app, source type and source instance. opts may be nil.
+func (l *LogSender) NewHandler(appID, sourceType, sourceInstance string, opts *HandlerOptions) *Handler {
+	if opts == nil {
+		opts = &HandlerOptions{}
+	}
+	h := &Handler{
+		sender:         l,
+		appID:          appID,
+		sourceType:     sourceType,
+		sourceInstance: sourceInstance,
+		level:          opts.Level,
+		errorLevel:     opts.ErrorLevel,
+		buf:            &bytes.Buffer{},
+		lock:           &sync.Mutex{},
+	}
+	if h.level == nil {
+		h.level = slog.LevelInfo
+	}
+	if h.errorLevel == nil {
+		h.errorLevel = slog.LevelError
+	}
+
+	innerOpts := &slog.HandlerOptions{ReplaceAttr: removeTime}
+	if opts.JSON {
+		h.inner = slog.NewJSONHandler(h.buf, innerOpts)
+	} else {
+		h.inner = slog.NewTextHandler(h.buf, innerOpts)
+	}
+	return h
+}
+
+// Enabled reports whether records of the given level are sent.
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle sends the record as a log message.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	message, err := h.render(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	msgType := events.LogMessage_OUT
+	if r.Level >= h.errorLevel.Level() {
+		msgType = events.LogMessage_ERR
+	}
+
+	tags := h.sender.copyDefaultTags()
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	recordTags := h.tags[:len(h.tags):len(h.tags)]
+	r.Attrs(func(a slog.Attr) bool {
+		recordTags = appendAttrTags(recordTags, h.groups, a)
+		return true
+	})
+	for _, tag := range recordTags {
+		addTag(tags, tag[0], tag[1])
+	}
+
+	chainer := h.sender.LogMessage(message, msgType).
+		SetAppId(h.appID).
+		SetSourceType(h.sourceType).
+		SetSourceInstance(h.sourceInstance)
+	if !r.Time.IsZero() {
+		chainer = chainer.SetTimestamp(r.Time.UnixNano())
+	}
+	for k, v := range tags {
+		chainer = chainer.SetTag(k, v)
+	}
+	return chainer.(ContextLogChainer).SendContext(ctx)
+}
+
+// WithAttrs returns a handler that adds attrs to every record.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.inner = h.inner.WithAttrs(attrs)
+	h2.tags = append([][2]string(nil), h.tags...)
+	for _, a := range attrs {
+		h2.tags = appendAttrTags(h2.tags, h.groups, a)
+	}
+	return &h2
+}
+
+// WithGroup returns a handler that qualifies the attributes of every record
+// with name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.inner = h.inner.WithGroup(name)
+	h2.groups = append(append([]string(nil), h.groups...), name)
+	return &h2
+}
+
+func (h *Handler) render(ctx context.Context, r slog.Record) ([]byte, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(append([]byte(nil), h.buf.Bytes()...), []byte("\n")), nil
+}
+
+// appendAttrTags appends the tags for an attribute, flattening groups.
+func appendAttrTags(tags [][2]string, groups []string, a slog.Attr) [][2]string {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return tags
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(append([]string(nil), groups...), a.Key)
+		}
+		for _, member := range a.Value.Group() {
+			tags = appendAttrTags(tags, groups, member)
+		}
+		return tags
+	}
+	key := strings.Join(append(append([]string(nil), groups...), a.Key), ".")
+	return append(tags, [2]string{key, a.Value.String()})
+}
+
+// addTag sets the tag unless it would exceed the limits on tags.
+func addTag(tags map[string]string, key, value string) {
+	if utf8.RuneCountInString(key) > maxTagLen || utf8.RuneCountInString(value) > maxTagLen {
+		return
+	}
+	if _, ok := tags[key]; !ok && len(tags) >= maxTags {
+		return
+	}
+	tags[key] = value
+}
+
+func removeTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
@@ -0,0 +1,126 @@
+package log_sender_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/log_sender"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		emitter *fake.FakeEventEmitter
+		sender  *log_sender.LogSender
+		logger  *slog.Logger
+	)
+
+	var lastEnvelope = func() *events.Envelope {
+		envelopes := emitter.GetEnvelopes()
+		Expect(envelopes).ToNot(BeEmpty())
+		return envelopes[len(envelopes)-1]
+	}
+
+	BeforeEach(func() {
+		metrics.Initialize(nil, newMockMetricBatcher())
+		emitter = fake.NewFakeEventEmitter("test-origin")
+		sender = log_sender.NewLogSender(emitter)
+		logger = slog.New(sender.NewHandler("app-id", "APP", "0", nil))
+	})
+
+	It("sends records as log messages of the app", func() {
+		logger.Info("request served", "status", 200)
+
+		log := lastEnvelope().GetLogMessage()
+		Expect(string(log.GetMessage())).To(Equal(`level=INFO msg="request served" status=200`))
+		Expect(log.GetMessageType()).To(Equal(events.LogMessage_OUT))
+		Expect(log.GetAppId()).To(Equal("app-id"))
+		Expect(log.GetSourceType()).To(Equal("APP"))
+		Expect(log.GetSourceInstance()).To(Equal("0"))
+		Expect(log.GetTimestamp()).To(BeNumerically("~", time.Now().UnixNano(), int64(time.Second)))
+	})
+
+	It("sends errors as ERR", func() {
+		logger.Warn("slow")
+		Expect(lastEnvelope().GetLogMessage().GetMessageType()).To(Equal(events.LogMessage_OUT))
+
+		logger.Error("failed")
+		Expect(lastEnvelope().GetLogMessage().GetMessageType()).To(Equal(events.LogMessage_ERR))
+	})
+
+	It("only sends records of the configured levels", func() {
+		logger = slog.New(sender.NewHandler("app-id", "APP", "0", &log_sender.HandlerOptions{
+			Level:      slog.LevelWarn,
+			ErrorLevel: slog.LevelWarn,
+		}))
+
+		logger.Info("ignored")
+		logger.Warn("slow")
+
+		Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+		Expect(lastEnvelope().GetLogMessage().GetMessageType()).To(Equal(events.LogMessage_ERR))
+	})
+
+	It("renders records as JSON", func() {
+		logger = slog.New(sender.NewHandler("app-id", "APP", "0", &log_sender.HandlerOptions{JSON: true}))
+
+		logger.Info("hello", "user", "alice")
+
+		var rendered map[string]interface{}
+		Expect(json.Unmarshal(lastEnvelope().GetLogMessage().GetMessage(), &rendered)).To(Succeed())
+		Expect(rendered).To(Equal(map[string]interface{}{"level": "INFO", "msg": "hello", "user": "alice"}))
+	})
+
+	It("sets attributes as tags, qualified by their groups", func() {
+		logger.With("component", "router").
+			WithGroup("request").
+			With("method", "GET").
+			Info("served", slog.Group("response", "status", 200), "path", "/")
+
+		envelope := lastEnvelope()
+		Expect(envelope.GetTags()).To(Equal(map[string]string{
+			"component":               "router",
+			"request.method":          "GET",
+			"request.response.status": "200",
+			"request.path":            "/",
+		}))
+		Expect(string(envelope.GetLogMessage().GetMessage())).To(Equal(
+			`level=INFO msg=served component=router request.method=GET request.response.status=200 request.path=/`,
+		))
+	})
+
+	It("keeps the tags of derived handlers apart", func() {
+		base := logger.With("component", "router")
+		base.With("a", "1").Info("first")
+		base.With("b", "2").Info("second")
+
+		Expect(emitter.GetEnvelopes()[0].GetTags()).To(Equal(map[string]string{"component": "router", "a": "1"}))
+		Expect(emitter.GetEnvelopes()[1].GetTags()).To(Equal(map[string]string{"component": "router", "b": "2"}))
+	})
+
+	It("only sets the attributes that fit within the limits on tags", func() {
+		Expect(sender.SetDefaultTags(map[string]string{"az": "z1"})).To(Succeed())
+		var args []interface{}
+		for i := 0; i < 12; i++ {
+			args = append(args, fmt.Sprintf("key%02d", i), i)
+		}
+		args = append(args, "long", strings.Repeat("x", 257))
+
+		logger.Info("many", args...)
+
+		envelope := lastEnvelope()
+		Expect(envelope.GetTags()).To(HaveLen(10))
+		Expect(envelope.GetTags()).To(HaveKeyWithValue("az", "z1"))
+		Expect(envelope.GetTags()).To(HaveKeyWithValue("key08", "8"))
+		Expect(envelope.GetTags()).ToNot(HaveKey("key09"))
+		Expect(string(envelope.GetLogMessage().GetMessage())).To(ContainSubstring("key11=11"))
+	})
+})
@@ -0,0 +1,116 @@
+package log_sender
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+type logWriter struct {
+	sender                            *LogSender
+	appID, sourceType, sourceInstance string
+	send                              func(appID, message, sourceType, sourceInstance string) error
+
+	lock    sync.Mutex
+	pending []byte
+	closed  bool
+}
+
+// NewWriter returns a writer that sends each line written to it as a log
+// message of the given type, like ScanLogStream does for the lines read from
+// a reader. A partial line is kept until the rest of it is written, or the
+// writer is closed. Lines longer than the scanner accepts are dropped with
+// the same error log message.
+func (l *LogSender) NewWriter(appID, sourceType, sourceInstance string, msgType events.LogMessage_MessageType) io.WriteCloser {
+	w := &logWriter{
+		sender:         l,
+		appID:          appID,
+		sourceType:     sourceType,
+		sourceInstance: sourceInstance,
+		send:           l.SendAppLog,
+	}
+	if msgType == events.LogMessage_ERR {
+		w.send = l.SendAppErrorLog
+	}
+	return w
+}
+
+// Write sends every complete line in p. It stops at the first line that
+// cannot be sent, for a reason other than its size, and returns the error.
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	written := 0
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.pending = append(w.pending, p...)
+			written += len(p)
+			w.dropTooLong()
+			return written, nil
+		}
+
+		line := append(w.pending, p[:i]...)
+		w.pending = w.pending[:0]
+		p = p[i+1:]
+		written += i + 1
+
+		if len(line) > bufio.MaxScanTokenSize {
+			w.pending = line
+			w.dropTooLong()
+			line, w.pending = w.pending, nil
+		}
+		if err := w.sendLine(line); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close sends the partial line that was written last, if any. Writes after
+// Close fail.
+func (w *logWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	line := w.pending
+	w.pending = nil
+	return w.sendLine(line)
+}
+
+// dropTooLong drops pending text that has grown beyond what the scanner
+// accepts, keeping what follows it as the start of a new line, as
+// ScanLogStream does.
+func (w *logWriter) dropTooLong() {
+	for len(w.pending) > bufio.MaxScanTokenSize {
+		w.sender.isMessageTooLong(bufio.ErrTooLong, w.appID, w.sourceType, w.sourceInstance)
+		w.pending = append(w.pending[:0], w.pending[bufio.MaxScanTokenSize:]...)
+	}
+}
+
+func (w *logWriter) sendLine(line []byte) error {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(strings.TrimSpace(string(line))) == 0 {
+		return nil
+	}
+
+	err := w.send(w.appID, string(line), w.sourceType, w.sourceInstance)
+	if w.sender.isMessageTooLong(err, w.appID, w.sourceType, w.sourceInstance) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+package log_sender_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dropsonde_emitter "github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/log_sender"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		emitter *fake.FakeEventEmitter
+		sender  *log_sender.LogSender
+		writer  io.WriteCloser
+	)
+
+	BeforeEach(func() {
+		metrics.Initialize(nil, newMockMetricBatcher())
+		emitter = fake.NewFakeEventEmitter("test-origin")
+		sender = log_sender.NewLogSender(emitter)
+		writer = sender.NewWriter("someId", "app", "0", events.LogMessage_OUT)
+	})
+
+	It("sends each complete line", func() {
+		n, err := fmt.Fprint(writer, "line 1\nline 2\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(14))
+
+		Expect(getLogMessages(emitter.GetMessages())).To(Equal([]string{"line 1", "line 2"}))
+		log := emitter.GetMessages()[0].Event.(*events.LogMessage)
+		Expect(log.GetMessageType()).To(Equal(events.LogMessage_OUT))
+		Expect(log.GetAppId()).To(Equal("someId"))
+		Expect(log.GetSourceType()).To(Equal("app"))
+		Expect(log.GetSourceInstance()).To(Equal("0"))
+	})
+
+	It("buffers partial lines until they are complete", func() {
+		fmt.Fprint(writer, "par")
+		fmt.Fprint(writer, "tial")
+		Expect(emitter.GetMessages()).To(BeEmpty())
+
+		fmt.Fprint(writer, " line\r\nnext")
+
+		Expect(getLogMessages(emitter.GetMessages())).To(Equal([]string{"partial line"}))
+	})
+
+	It("sends the remainder on Close and fails writes after it", func() {
+		fmt.Fprint(writer, "one\nremainder")
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(getLogMessages(emitter.GetMessages())).To(Equal([]string{"one", "remainder"}))
+
+		_, err := fmt.Fprint(writer, "late\n")
+		Expect(err).To(MatchError(io.ErrClosedPipe))
+	})
+
+	It("ignores empty lines", func() {
+		fmt.Fprint(writer, "one\n\n \ntwo\n")
+
+		Expect(getLogMessages(emitter.GetMessages())).To(Equal([]string{"one", "two"}))
+	})
+
+	It("sends error logs", func() {
+		writer = sender.NewWriter("someId", "app", "0", events.LogMessage_ERR)
+		fmt.Fprint(writer, "failed\n")
+
+		log := emitter.GetMessages()[0].Event.(*events.LogMessage)
+		Expect(log.GetMessageType()).To(Equal(events.LogMessage_ERR))
+	})
+
+	It("drops over-length lines like the scanner", func() {
+		fmt.Fprint(writer, strings.Repeat("x", 64*1024))
+		fmt.Fprint(writer, "x\nsmall message\n")
+
+		messages := getLogMessages(emitter.GetMessages())
+		Expect(messages).To(HaveLen(3))
+		Expect(messages[0]).To(Equal("Dropped log message: message too long (>64K without a newline)"))
+		Expect(messages[1]).To(Equal("x"))
+		Expect(messages[2]).To(Equal("small message"))
+	})
+
+	It("drops lines that are too large for the transport", func() {
+		emitter.ReturnError = &dropsonde_emitter.EnvelopeTooLargeError{
+			EventType: events.Envelope_LogMessage,
+			Size:      70000,
+			MaxSize:   dropsonde_emitter.MaxUDPPayloadSize,
+		}
+
+		_, err := fmt.Fprint(writer, "too large\nsmall message\n")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(getLogMessages(emitter.GetMessages())).To(Equal([]string{
+			"Dropped log message: message exceeds transport size limit",
+			"small message",
+		}))
+	})
+
+	It("returns other errors with the bytes written up to the failed line", func() {
+		emitter.ReturnError = errors.New("expected error")
+
+		n, err := fmt.Fprint(writer, "one\ntwo\n")
+
+		Expect(err).To(MatchError("expected error"))
+		Expect(n).To(Equal(4))
+	})
+})
@@ -0,0 +1,226 @@
+// Package metric_registry lets components declare the metrics they emit, so
+// that emission can be checked against the declarations and a catalog of
+// every metric can be published.
+//
+// Use
+//
+//	registry := metric_registry.New(metric_registry.Warn)
+//	registry.MustRegister(metric_registry.Descriptor{
+//		Name:    "requests",
+//		Kind:    metric_registry.Counter,
+//		TagKeys: []string{"route"},
+//		Help:    "Number of requests served.",
+//	})
+//	metrics.SetRegistry(registry)
+package metric_registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Kind is the type of event a metric is sent as.
+type Kind string
+
+const (
+	// Counter metrics are sent as CounterEvents.
+	Counter Kind = "counter"
+	// Gauge metrics are sent as ValueMetrics.
+	Gauge Kind = "gauge"
+	// Histogram metrics are aggregated in process and sent as bucket,
+	// count, sum and percentile events.
+	Histogram Kind = "histogram"
+	// Meter metrics are counted in process and sent as a counter and
+	// per_second rates.
+	Meter Kind = "meter"
+)
+
+// maxWarnedViolations bounds the memory used to remember which violations
+// were logged. Beyond it, new violations are no longer logged.
+const maxWarnedViolations = 1 << 16
+
+// Mode selects what happens when a metric does not match its declaration.
+type Mode int
+
+const (
+	// Warn logs each distinct violation once, up to a limit, and lets the
+	// metric be sent.
+	Warn Mode = iota
+	// Strict rejects the metric with an error.
+	Strict
+)
+
+// Descriptor declares a metric.
+type Descriptor struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	// Unit is the unit the metric must be sent with. It is not checked if
+	// empty, or for counters, which are sent without a unit.
+	Unit string `json:"unit,omitempty"`
+	// TagKeys are the tag keys the metric may be sent with.
+	TagKeys []string `json:"tag_keys,omitempty"`
+	Help    string   `json:"help,omitempty"`
+}
+
+// Registry holds the metrics declared by the components of a process.
+type Registry struct {
+	mode Mode
+
+	lock        sync.RWMutex
+	descriptors map[string]Descriptor
+	warned      map[string]struct{}
+}
+
+// New creates an empty Registry that enforces declarations in the given mode.
+func New(mode Mode) *Registry {
+	return &Registry{
+		mode:        mode,
+		descriptors: make(map[string]Descriptor),
+		warned:      make(map[string]struct{}),
+	}
+}
+
+// Mode returns the mode the registry was created with.
+func (r *Registry) Mode() Mode {
+	return r.mode
+}
+
+// Register declares the given metrics. Declaring a metric again is allowed
+// if the declarations are identical. Register returns an error, and declares
+// none of the metrics, if a metric has no name or kind or conflicts with an
+// existing declaration.
+func (r *Registry) Register(descriptors ...Descriptor) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, d := range descriptors {
+		if d.Name == "" {
+			return fmt.Errorf("metric_registry: metric has no name")
+		}
+		if d.Kind == "" {
+			return fmt.Errorf("metric_registry: metric %q has no kind", d.Name)
+		}
+		if existing, ok := r.descriptors[d.Name]; ok && !existing.equal(d) {
+			return fmt.Errorf("metric_registry: metric %q is already declared as a %s with unit %q", d.Name, existing.Kind, existing.Unit)
+		}
+	}
+
+	for _, d := range descriptors {
+		d.TagKeys = append([]string(nil), d.TagKeys...)
+		sort.Strings(d.TagKeys)
+		r.descriptors[d.Name] = d
+	}
+	return nil
+}
+
+// MustRegister is like Register but panics if the metrics cannot be declared.
+func (r *Registry) MustRegister(descriptors ...Descriptor) {
+	if err := r.Register(descriptors...); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the declaration of the named metric.
+func (r *Registry) Lookup(name string) (Descriptor, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	d, ok := r.descriptors[name]
+	return d, ok
+}
+
+// Check verifies that a metric sent with the given kind, unit and tag keys
+// matches its declaration. In Strict mode a mismatch is returned as an
+// error; in Warn mode it is logged once and Check returns nil.
+func (r *Registry) Check(name string, kind Kind, unit string, tagKeys ...string) error {
+	err := r.violation(name, kind, unit, tagKeys)
+	if err == nil || r.mode == Strict {
+		return err
+	}
+
+	msg := err.Error()
+	r.lock.Lock()
+	_, warned := r.warned[msg]
+	first := !warned && len(r.warned) < maxWarnedViolations
+	if first {
+		r.warned[msg] = struct{}{}
+	}
+	reachedLimit := first && len(r.warned) == maxWarnedViolations
+	r.lock.Unlock()
+
+	if first {
+		log.Print(msg)
+	}
+	if reachedLimit {
+		log.Printf("metric_registry: logged %d distinct violations, further ones are not logged", maxWarnedViolations)
+	}
+	return nil
+}
+
+func (r *Registry) violation(name string, kind Kind, unit string, tagKeys []string) error {
+	d, ok := r.Lookup(name)
+	if !ok {
+		return fmt.Errorf("metric_registry: metric %q is not declared", name)
+	}
+	if d.Kind != kind {
+		return fmt.Errorf("metric_registry: metric %q is declared as a %s but sent as a %s", name, d.Kind, kind)
+	}
+	if d.Unit != "" && kind != Counter && d.Unit != unit {
+		return fmt.Errorf("metric_registry: metric %q is declared with unit %q but sent with unit %q", name, d.Unit, unit)
+	}
+	for _, key := range tagKeys {
+		i := sort.SearchStrings(d.TagKeys, key)
+		if i == len(d.TagKeys) || d.TagKeys[i] != key {
+			return fmt.Errorf("metric_registry: metric %q is sent with undeclared tag %q", name, key)
+		}
+	}
+	return nil
+}
+
+// Descriptors returns every declared metric, sorted by name.
+func (r *Registry) Descriptors() []Descriptor {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	descriptors := make([]Descriptor, 0, len(r.descriptors))
+	for _, d := range r.descriptors {
+		descriptors = append(descriptors, d)
+	}
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].Name < descriptors[j].Name
+	})
+	return descriptors
+}
+
+// WriteCatalog writes every declared metric to w as a JSON document of the
+// form {"metrics": [...]}.
+func (r *Registry) WriteCatalog(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Metrics []Descriptor `json:"metrics"`
+	}{r.Descriptors()})
+}
+
+func (d Descriptor) equal(other Descriptor) bool {
+	if d.Name != other.Name || d.Kind != other.Kind || d.Unit != other.Unit || d.Help != other.Help {
+		return false
+	}
+	if len(d.TagKeys) != len(other.TagKeys) {
+		return false
+	}
+	keys := make(map[string]struct{}, len(d.TagKeys))
+	for _, k := range d.TagKeys {
+		keys[k] = struct{}{}
+	}
+	for _, k := range other.TagKeys {
+		if _, ok := keys[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+package metric_registry_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestMetricRegistry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MetricRegistry Suite")
+}
@@ -0,0 +1,137 @@
+package metric_registry_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var (
+		registry *metric_registry.Registry
+		requests metric_registry.Descriptor
+	)
+
+	BeforeEach(func() {
+		registry = metric_registry.New(metric_registry.Strict)
+		requests = metric_registry.Descriptor{
+			Name:    "requests",
+			Kind:    metric_registry.Counter,
+			TagKeys: []string{"route", "method"},
+			Help:    "Number of requests served.",
+		}
+		Expect(registry.Register(requests, metric_registry.Descriptor{
+			Name: "queueDepth",
+			Kind: metric_registry.Gauge,
+			Unit: "count",
+		})).To(Succeed())
+	})
+
+	Describe("Register", func() {
+		It("allows identical declarations", func() {
+			requests.TagKeys = []string{"method", "route"}
+			Expect(registry.Register(requests)).To(Succeed())
+		})
+
+		It("rejects conflicting declarations", func() {
+			err := registry.Register(metric_registry.Descriptor{Name: "requests", Kind: metric_registry.Gauge, Unit: "ms"})
+			Expect(err).To(MatchError(ContainSubstring(`"requests" is already declared as a counter`)))
+		})
+
+		It("declares nothing if any declaration is invalid", func() {
+			err := registry.Register(
+				metric_registry.Descriptor{Name: "latency", Kind: metric_registry.Histogram},
+				metric_registry.Descriptor{Name: "nameless"},
+			)
+			Expect(err).To(HaveOccurred())
+
+			_, ok := registry.Lookup("latency")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("panics in MustRegister if the declaration is invalid", func() {
+			Expect(func() {
+				registry.MustRegister(metric_registry.Descriptor{Kind: metric_registry.Counter})
+			}).To(Panic())
+		})
+	})
+
+	Describe("Check", func() {
+		It("accepts metrics that match their declaration", func() {
+			Expect(registry.Check("requests", metric_registry.Counter, "", "route")).To(Succeed())
+			Expect(registry.Check("queueDepth", metric_registry.Gauge, "count")).To(Succeed())
+		})
+
+		It("rejects undeclared metrics", func() {
+			Expect(registry.Check("unknown", metric_registry.Counter, "")).To(MatchError(ContainSubstring(`"unknown" is not declared`)))
+		})
+
+		It("rejects metrics of another kind", func() {
+			Expect(registry.Check("requests", metric_registry.Gauge, "count")).To(MatchError(ContainSubstring("declared as a counter but sent as a gauge")))
+		})
+
+		It("rejects metrics with another unit", func() {
+			Expect(registry.Check("queueDepth", metric_registry.Gauge, "bytes")).To(MatchError(ContainSubstring(`declared with unit "count"`)))
+		})
+
+		It("rejects undeclared tags", func() {
+			Expect(registry.Check("requests", metric_registry.Counter, "", "user")).To(MatchError(ContainSubstring(`undeclared tag "user"`)))
+		})
+
+		Context("in warn mode", func() {
+			var logOutput *bytes.Buffer
+
+			BeforeEach(func() {
+				registry = metric_registry.New(metric_registry.Warn)
+				logOutput = new(bytes.Buffer)
+				log.SetOutput(logOutput)
+			})
+
+			It("logs each violation once and accepts the metric", func() {
+				Expect(registry.Check("unknown", metric_registry.Counter, "")).To(Succeed())
+				Expect(registry.Check("unknown", metric_registry.Counter, "")).To(Succeed())
+
+				Expect(bytes.Count(logOutput.Bytes(), []byte(`"unknown" is not declared`))).To(Equal(1))
+			})
+
+			It("stops logging new violations beyond a limit", func() {
+				for i := 0; i < 1<<16; i++ {
+					Expect(registry.Check(fmt.Sprintf("unknown-%d", i), metric_registry.Counter, "")).To(Succeed())
+				}
+				Expect(bytes.Count(logOutput.Bytes(), []byte("further ones are not logged"))).To(Equal(1))
+				logOutput.Reset()
+
+				Expect(registry.Check("unknown-new", metric_registry.Counter, "")).To(Succeed())
+				Expect(registry.Check("unknown-0", metric_registry.Counter, "")).To(Succeed())
+				Expect(logOutput.Len()).To(BeZero())
+			})
+		})
+	})
+
+	Describe("WriteCatalog", func() {
+		It("writes every declared metric as JSON, sorted by name", func() {
+			var buf bytes.Buffer
+			Expect(registry.WriteCatalog(&buf)).To(Succeed())
+
+			var catalog struct {
+				Metrics []map[string]interface{} `json:"metrics"`
+			}
+			Expect(json.Unmarshal(buf.Bytes(), &catalog)).To(Succeed())
+			Expect(catalog.Metrics).To(Equal([]map[string]interface{}{
+				{"name": "queueDepth", "kind": "gauge", "unit": "count"},
+				{
+					"name":     "requests",
+					"kind":     "counter",
+					"tag_keys": []interface{}{"method", "route"},
+					"help":     "Number of requests served.",
+				},
+			}))
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package metric_sender
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// OverflowTagValue replaces every tag value of a series that would exceed
+	// its metric's cardinality limit, so that all such series are folded into
+	// one.
+	OverflowTagValue = "__overflow__"
+
+	// OverflowCounterName is the counter that reports how many series of a
+	// metric were folded. The metric is given by the OverflowMetricTag.
+	OverflowCounterName = "tagCardinalityOverflow"
+	OverflowMetricTag   = "metric"
+
+	// maxTrackedOverflowSeries bounds the memory used to recognise series
+	// that were already folded. Beyond it, newly folded series are still
+	// folded but no longer counted.
+	maxTrackedOverflowSeries = 1 << 16
+)
+
+// CardinalityLimiter bounds the number of distinct tag sets of each metric
+// name. The first series of a metric, up to its limit, are admitted; later
+// ones are folded into a single series whose tag values are all
+// OverflowTagValue. A MetricSender applies one to everything it sends, and
+// package metricbatcher applies one to each flush window.
+type CardinalityLimiter struct {
+	// enabled is set once any limit is configured, so that limiters without
+	// limits never take the lock.
+	enabled int32
+
+	lock         sync.Mutex
+	defaultLimit int
+	limits       map[string]int
+	admitted     map[string]map[string]struct{}
+	folded       map[string]map[string]struct{}
+}
+
+// NewCardinalityLimiter creates a CardinalityLimiter without any limits.
+func NewCardinalityLimiter() *CardinalityLimiter {
+	return &CardinalityLimiter{
+		limits:   make(map[string]int),
+		admitted: make(map[string]map[string]struct{}),
+		folded:   make(map[string]map[string]struct{}),
+	}
+}
+
+// SetLimit limits the number of series of the named metric. A limit of 0
+// removes the limit.
+func (l *CardinalityLimiter) SetLimit(name string, limit int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limits[name] = limit
+	atomic.StoreInt32(&l.enabled, 1)
+}
+
+// SetDefaultLimit sets the limit for metrics without a limit of their own.
+func (l *CardinalityLimiter) SetDefaultLimit(limit int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.defaultLimit = limit
+	atomic.StoreInt32(&l.enabled, 1)
+}
+
+// Limit applies the limit of the named metric to the series with the given
+// tags. It returns nil if the series is admitted, and otherwise the tags to
+// send it with instead. newlyFolded reports whether the series was folded
+// for the first time since the last Reset. Untagged series, series that are
+// already folded and the OverflowCounterName counter are always admitted.
+func (l *CardinalityLimiter) Limit(name string, tags map[string]string) (overflow map[string]string, newlyFolded bool) {
+	if len(tags) == 0 || name == OverflowCounterName || atomic.LoadInt32(&l.enabled) == 0 || isOverflow(tags) {
+		return nil, false
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limit, ok := l.limits[name]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if limit <= 0 {
+		return nil, false
+	}
+
+	key := SeriesKey(name, tags)
+	admitted := l.admitted[name]
+	if _, ok := admitted[key]; ok {
+		return nil, false
+	}
+	if len(admitted) < limit {
+		if admitted == nil {
+			admitted = make(map[string]struct{})
+			l.admitted[name] = admitted
+		}
+		admitted[key] = struct{}{}
+		return nil, false
+	}
+
+	folded := l.folded[name]
+	if _, ok := folded[key]; !ok && len(folded) < maxTrackedOverflowSeries {
+		if folded == nil {
+			folded = make(map[string]struct{})
+			l.folded[name] = folded
+		}
+		folded[key] = struct{}{}
+		newlyFolded = true
+	}
+	return overflowTags(tags), newlyFolded
+}
+
+// Reset forgets every series seen, and returns the number of series of each
+// metric folded since the previous Reset.
+func (l *CardinalityLimiter) Reset() map[string]int {
+	if atomic.LoadInt32(&l.enabled) == 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	counts := make(map[string]int, len(l.folded))
+	for name, folded := range l.folded {
+		counts[name] = len(folded)
+	}
+	l.admitted = make(map[string]map[string]struct{})
+	l.folded = make(map[string]map[string]struct{})
+	return counts
+}
+
+func overflowTags(tags map[string]string) map[string]string {
+	overflow := make(map[string]string, len(tags))
+	for k := range tags {
+		overflow[k] = OverflowTagValue
+	}
+	return overflow
+}
+
+func isOverflow(tags map[string]string) bool {
+	for _, v := range tags {
+		if v != OverflowTagValue {
+			return false
+		}
+	}
+	return true
+}
@@ -1,12 +1,18 @@
 package metric_sender
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
+	"github.com/cloudfoundry/dropsonde/units"
 	"github.com/cloudfoundry/sonde-go/events"
-	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -14,6 +20,17 @@ const (
 	maxTags   = 10
 )
 
+// StartTimeTag is the tag set on every CounterEvent to the time, in
+// nanoseconds since the Unix epoch, at which the MetricSender started keeping
+// totals. A change in its value tells receivers that totals were reset, for
+// example because the process restarted. Counters that already carry the
+// maximum number of tags are sent without it.
+const StartTimeTag = "start_time"
+
+// maxTrackedCounterSeries bounds the memory used to keep the totals of
+// counters. Beyond it, counters of new series are sent without a total.
+const maxTrackedCounterSeries = 1 << 16
+
 type EventEmitter interface {
 	Emit(events.Event) error
 	EmitEnvelope(*events.Envelope) error
@@ -36,14 +53,108 @@ type CounterChainer interface {
 	Add(delta uint64) error
 }
 
-// A MetricSender emits metric events.
+// ContextValueChainer is a ValueChainer that can also be sent with a
+// context. The ValueChainers of a MetricSender implement it.
+type ContextValueChainer interface {
+	ValueChainer
+	SendContext(ctx context.Context) error
+}
+
+// ContextContainerMetricChainer is a ContainerMetricChainer that can also be
+// sent with a context. The ContainerMetricChainers of a MetricSender
+// implement it.
+type ContextContainerMetricChainer interface {
+	ContainerMetricChainer
+	SendContext(ctx context.Context) error
+}
+
+// QuotaContainerMetricChainer is a ContainerMetricChainer that can also set
+// the quotas of the container. The ContainerMetricChainers of a MetricSender
+// implement it.
+type QuotaContainerMetricChainer interface {
+	ContainerMetricChainer
+	SetMemoryQuota(bytes uint64) QuotaContainerMetricChainer
+	SetDiskQuota(bytes uint64) QuotaContainerMetricChainer
+}
+
+// ContextCounterChainer is a CounterChainer that can also be sent with a
+// context. The CounterChainers of a MetricSender implement it.
+type ContextCounterChainer interface {
+	CounterChainer
+	IncrementContext(ctx context.Context) error
+	AddContext(ctx context.Context, delta uint64) error
+}
+
+// A MetricSender emits metric events. It keeps a running total for each
+// counter, identified by its name and tags, and sets it as the Total of every
+// CounterEvent it sends.
 type MetricSender struct {
 	eventEmitter EventEmitter
+	totals       *counterTotals
+	limiter      *CardinalityLimiter
+	suppressor   *valueSuppressor
+	defaultTags  atomic.Value
+	unitMode     int32
 }
 
 // NewMetricSender instantiates a MetricSender with the given EventEmitter.
 func NewMetricSender(eventEmitter EventEmitter) *MetricSender {
-	return &MetricSender{eventEmitter: eventEmitter}
+	return &MetricSender{
+		eventEmitter: eventEmitter,
+		totals:       newCounterTotals(time.Now()),
+		limiter:      NewCardinalityLimiter(),
+		suppressor:   newValueSuppressor(),
+	}
+}
+
+// SetDefaultTags sets tags that are added to every metric sent through the
+// chaining functions and the legacy functions other than Send. Tags set on a
+// chainer take precedence, and the limit on the number of tags applies to
+// the merged set. It returns an error, and leaves the default tags unchanged,
+// if the tags exceed the limits on tags.
+func (ms *MetricSender) SetDefaultTags(tags map[string]string) error {
+	if err := ValidateTags(tags); err != nil {
+		return err
+	}
+
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	ms.defaultTags.Store(copied)
+	return nil
+}
+
+// SetCardinalityLimit limits the number of distinct tag sets the named value
+// or counter metric is sent with. Once limit series have been sent, the tag
+// values of any new series are replaced by OverflowTagValue, and each such
+// series is counted once by the OverflowCounterName counter. A limit of 0
+// removes the limit.
+func (ms *MetricSender) SetCardinalityLimit(name string, limit int) {
+	ms.limiter.SetLimit(name, limit)
+}
+
+// SetDefaultCardinalityLimit sets the limit for metrics without a limit of
+// their own. See SetCardinalityLimit.
+func (ms *MetricSender) SetDefaultCardinalityLimit(limit int) {
+	ms.limiter.SetDefaultLimit(limit)
+}
+
+// SetUnitMode sets how the units of value metrics are checked. By default
+// they are sent unchecked. In units.Permissive and units.Strict mode, known
+// spellings of units, such as "milliseconds", are replaced by the canonical
+// unit, and in units.Strict mode sending a value with an unknown unit
+// returns an error.
+func (ms *MetricSender) SetUnitMode(mode units.Mode) {
+	atomic.StoreInt32(&ms.unitMode, int32(mode))
+}
+
+// SuppressUnchangedValues drops ValueMetrics whose value equals the last one
+// sent for the same name and tags, unless none has been sent for maxSilence,
+// so that receivers can still tell the series is alive. Send returns nil for
+// a dropped value. A maxSilence of 0 turns suppression off.
+func (ms *MetricSender) SuppressUnchangedValues(maxSilence time.Duration) {
+	ms.suppressor.setMaxSilence(maxSilence)
 }
 
 // Send sends an events.Event.
@@ -55,7 +166,7 @@ func (ms *MetricSender) Send(ev events.Event) error {
 // http://metrics20.org/spec/#units for a specification of acceptable units.
 // Returns an error if one occurs while sending the event.
 func (ms *MetricSender) SendValue(name string, value float64, unit string) error {
-	return ms.eventEmitter.Emit(&events.ValueMetric{Name: &name, Value: &value, Unit: &unit})
+	return ms.Value(name, value, unit).Send()
 }
 
 // IncrementCounter sends an event to increment the named counter by one.
@@ -69,7 +180,7 @@ func (ms *MetricSender) IncrementCounter(name string) error {
 // (positive) delta. Maintaining the value of the counter is the responsibility
 // of the receiver, as with IncrementCounter.
 func (ms *MetricSender) AddToCounter(name string, delta uint64) error {
-	return ms.eventEmitter.Emit(&events.CounterEvent{Name: &name, Delta: &delta})
+	return ms.Counter(name).Add(delta)
 }
 
 // SendContainerMetric sends a metric that records resource usage of an app in a container.
@@ -77,138 +188,400 @@ func (ms *MetricSender) AddToCounter(name string, delta uint64) error {
 // metrics are CPU percentage, memory and disk usage in bytes. Returns an error if one occurs
 // when sending the metric.
 func (ms *MetricSender) SendContainerMetric(applicationId string, instanceIndex int32, cpuPercentage float64, memoryBytes uint64, diskBytes uint64) error {
-	return ms.eventEmitter.Emit(&events.ContainerMetric{ApplicationId: &applicationId, InstanceIndex: &instanceIndex, CpuPercentage: &cpuPercentage, MemoryBytes: &memoryBytes, DiskBytes: &diskBytes})
+	return ms.ContainerMetric(applicationId, instanceIndex, cpuPercentage, memoryBytes, diskBytes).Send()
 }
 
 // Value creates a value metric that can be manipulated via cascading calls
 // and then sent.
 func (ms *MetricSender) Value(name string, value float64, unit string) ValueChainer {
-	chainer := valueChainer{}
-	chainer.emitter = ms.eventEmitter
-	chainer.envelope = &events.Envelope{
-		Origin:    proto.String(ms.eventEmitter.Origin()),
-		EventType: events.Envelope_ValueMetric.Enum(),
-		ValueMetric: &events.ValueMetric{
-			Name:  proto.String(name),
-			Value: proto.Float64(value),
-			Unit:  proto.String(unit),
-		},
+	e := &valueEnvelope{name: name, value: value, unit: unit, suppressor: ms.suppressor}
+	e.init(ms.eventEmitter, events.Envelope_ValueMetric)
+	if mode := units.Mode(atomic.LoadInt32(&ms.unitMode)); mode != units.Unchecked {
+		if canonical, ok := units.Normalize(unit); ok {
+			e.unit = canonical
+		} else if mode == units.Strict {
+			e.err = fmt.Errorf("Unknown unit %q", unit)
+		}
 	}
-	return chainer
+	e.envelope.Tags = ms.copyDefaultTags()
+	e.limit(ms, e.name)
+	e.metric.Name = &e.name
+	e.metric.Value = &e.value
+	e.metric.Unit = &e.unit
+	e.envelope.ValueMetric = &e.metric
+	return valueChainer{e}
 }
 
 // ContainerMetric creates a container metric that can be manipulated via
 // cascading calls and then sent.
 func (ms *MetricSender) ContainerMetric(appID string, instance int32, cpu float64, mem, disk uint64) ContainerMetricChainer {
-	chainer := containerMetricChainer{}
-	chainer.emitter = ms.eventEmitter
-	chainer.envelope = &events.Envelope{
-		Origin:    proto.String(ms.eventEmitter.Origin()),
-		EventType: events.Envelope_ContainerMetric.Enum(),
-		ContainerMetric: &events.ContainerMetric{
-			ApplicationId: proto.String(appID),
-			InstanceIndex: proto.Int32(instance),
-			CpuPercentage: proto.Float64(cpu),
-			MemoryBytes:   proto.Uint64(mem),
-			DiskBytes:     proto.Uint64(disk),
-		},
-	}
-	return chainer
+	e := &containerMetricEnvelope{appID: appID, instance: instance, cpu: cpu, mem: mem, disk: disk}
+	e.init(ms.eventEmitter, events.Envelope_ContainerMetric)
+	e.envelope.Tags = ms.copyDefaultTags()
+	e.metric.ApplicationId = &e.appID
+	e.metric.InstanceIndex = &e.instance
+	e.metric.CpuPercentage = &e.cpu
+	e.metric.MemoryBytes = &e.mem
+	e.metric.DiskBytes = &e.disk
+	e.envelope.ContainerMetric = &e.metric
+	return containerMetricChainer{e}
 }
 
 // Counter creates a counter event that can be manipulated via cascading calls
 // and then sent via Increment or Add.
 func (ms *MetricSender) Counter(name string) CounterChainer {
-	chainer := counterChainer{}
-	chainer.emitter = ms.eventEmitter
-	chainer.envelope = &events.Envelope{
-		Origin:    proto.String(ms.eventEmitter.Origin()),
-		EventType: events.Envelope_CounterEvent.Enum(),
-		CounterEvent: &events.CounterEvent{
-			Name: proto.String(name),
-		},
+	e := &counterEnvelope{name: name, totals: ms.totals}
+	e.init(ms.eventEmitter, events.Envelope_CounterEvent)
+	e.envelope.Tags = ms.copyDefaultTags()
+	e.limit(ms, e.name)
+	e.counter.Name = &e.name
+	e.envelope.CounterEvent = &e.counter
+	return counterChainer{e}
+}
+
+// copyDefaultTags returns a copy of the default tags for a new envelope, or
+// nil if there are none.
+func (ms *MetricSender) copyDefaultTags() map[string]string {
+	defaults, _ := ms.defaultTags.Load().(map[string]string)
+	if len(defaults) == 0 {
+		return nil
 	}
-	return chainer
+
+	tags := make(map[string]string, len(defaults)+1)
+	for k, v := range defaults {
+		tags[k] = v
+	}
+	return tags
+}
+
+// ValidateTags returns an error if there are more tags than an envelope may
+// carry, or if a key or value of a tag is too long.
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("Too many tags. Max of %d", maxTags)
+	}
+	for k, v := range tags {
+		if utf8.RuneCountInString(k) > maxTagLen || utf8.RuneCountInString(v) > maxTagLen {
+			return fmt.Errorf("Tag exceeds max length of %d", maxTagLen)
+		}
+	}
+	return nil
 }
 
 type envelopeEmitter interface {
 	EmitEnvelope(*events.Envelope) error
 }
 
-type chainer struct {
-	emitter  envelopeEmitter
-	envelope *events.Envelope
-	err      error
+type contextEnvelopeEmitter interface {
+	EmitEnvelopeContext(context.Context, *events.Envelope) error
+}
+
+// pendingEnvelope is an envelope under construction by a chainer. It holds
+// the storage for the envelope's scalar fields, and the typed envelopes
+// embedding it hold the storage for their event's fields, so that building
+// and sending an envelope costs a single allocation.
+type pendingEnvelope struct {
+	emitter   envelopeEmitter
+	envelope  events.Envelope
+	origin    string
+	eventType events.Envelope_EventType
+	timestamp int64
+	err       error
+
+	sender    *MetricSender
+	limitName string
+	limited   bool
+}
+
+func (p *pendingEnvelope) init(emitter EventEmitter, eventType events.Envelope_EventType) {
+	p.emitter = emitter
+	p.origin = emitter.Origin()
+	p.eventType = eventType
+	p.envelope.Origin = &p.origin
+	p.envelope.EventType = &p.eventType
+}
+
+func (p *pendingEnvelope) limit(sender *MetricSender, name string) {
+	p.sender = sender
+	p.limitName = name
 }
 
-func (c chainer) SetTag(key, value string) chainer {
-	if c.envelope.Tags == nil {
-		c.envelope.Tags = make(map[string]string)
+// applyLimit folds the envelope's tags if its series exceeds the cardinality
+// limit, and counts each newly folded series with the OverflowCounterName
+// counter. It only takes effect once per envelope.
+func (p *pendingEnvelope) applyLimit() {
+	if p.sender == nil || p.limited {
+		return
+	}
+	p.limited = true
+	overflow, newlyFolded := p.sender.limiter.Limit(p.limitName, p.envelope.Tags)
+	if overflow == nil {
+		return
+	}
+	p.envelope.Tags = overflow
+	if newlyFolded {
+		p.sender.Counter(OverflowCounterName).SetTag(OverflowMetricTag, p.limitName).Increment()
+	}
+}
+
+func (p *pendingEnvelope) setTag(key, value string) error {
+	if p.envelope.Tags == nil {
+		p.envelope.Tags = make(map[string]string)
 	}
 	if utf8.RuneCountInString(key) > maxTagLen || utf8.RuneCountInString(value) > maxTagLen {
-		return chainer{
-			err: fmt.Errorf("Tag exceeds max length of %d", maxTagLen),
-		}
+		return fmt.Errorf("Tag exceeds max length of %d", maxTagLen)
 	}
 
-	c.envelope.Tags[key] = value
-	if len(c.envelope.Tags) > maxTags {
-		return chainer{
-			err: fmt.Errorf("Too many tags. Max of %d", maxTags),
-		}
+	p.envelope.Tags[key] = value
+	if len(p.envelope.Tags) > maxTags {
+		return fmt.Errorf("Too many tags. Max of %d", maxTags)
 	}
-	return c
+	return nil
 }
 
-func (c chainer) Send() error {
-	if c.err != nil {
-		return c.err
+func (p *pendingEnvelope) Send() error {
+	return p.SendContext(context.Background())
+}
+
+// SendContext is like Send, but the emitter gives up once ctx is done.
+// Emitters that do not accept a context are only called if ctx is not yet
+// done.
+func (p *pendingEnvelope) SendContext(ctx context.Context) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.applyLimit()
+	p.timestamp = time.Now().UnixNano()
+	p.envelope.Timestamp = &p.timestamp
+	if emitter, ok := p.emitter.(contextEnvelopeEmitter); ok {
+		return emitter.EmitEnvelopeContext(ctx, &p.envelope)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	return p.emitter.EmitEnvelope(&p.envelope)
+}
 
-	c.envelope.Timestamp = proto.Int64(time.Now().UnixNano())
-	return c.emitter.EmitEnvelope(c.envelope)
+type valueEnvelope struct {
+	pendingEnvelope
+	metric     events.ValueMetric
+	name, unit string
+	value      float64
+	suppressor *valueSuppressor
 }
 
 type valueChainer struct {
-	chainer
+	*valueEnvelope
 }
 
 func (c valueChainer) SetTag(key, value string) ValueChainer {
-	c.chainer = c.chainer.SetTag(key, value)
+	if err := c.setTag(key, value); err != nil {
+		return valueChainer{&valueEnvelope{pendingEnvelope: pendingEnvelope{err: err}}}
+	}
 	return c
 }
 
+func (c valueChainer) Send() error {
+	return c.SendContext(context.Background())
+}
+
+// SendContext is like Send, but the emitter gives up once ctx is done.
+func (c valueChainer) SendContext(ctx context.Context) error {
+	if c.err != nil || c.suppressor == nil {
+		return c.pendingEnvelope.SendContext(ctx)
+	}
+
+	c.applyLimit()
+	now := time.Now()
+	key, suppressed := c.suppressor.suppress(c.name, c.envelope.Tags, c.value, now)
+	if suppressed {
+		return nil
+	}
+	if err := c.pendingEnvelope.SendContext(ctx); err != nil {
+		return err
+	}
+	c.suppressor.sent(key, c.value, now)
+	return nil
+}
+
+type containerMetricEnvelope struct {
+	pendingEnvelope
+	metric              events.ContainerMetric
+	appID               string
+	instance            int32
+	cpu                 float64
+	mem, disk           uint64
+	memQuota, diskQuota uint64
+}
+
 type containerMetricChainer struct {
-	chainer
+	*containerMetricEnvelope
 }
 
 func (c containerMetricChainer) SetTag(key, value string) ContainerMetricChainer {
-	c.chainer = c.chainer.SetTag(key, value)
+	if err := c.setTag(key, value); err != nil {
+		return containerMetricChainer{&containerMetricEnvelope{pendingEnvelope: pendingEnvelope{err: err}}}
+	}
+	return c
+}
+
+// SetMemoryQuota sets the memory limit of the container, in bytes.
+func (c containerMetricChainer) SetMemoryQuota(bytes uint64) QuotaContainerMetricChainer {
+	c.memQuota = bytes
+	c.metric.MemoryBytesQuota = &c.memQuota
+	return c
+}
+
+// SetDiskQuota sets the disk limit of the container, in bytes.
+func (c containerMetricChainer) SetDiskQuota(bytes uint64) QuotaContainerMetricChainer {
+	c.diskQuota = bytes
+	c.metric.DiskBytesQuota = &c.diskQuota
 	return c
 }
 
+type counterEnvelope struct {
+	pendingEnvelope
+	counter events.CounterEvent
+	name    string
+	delta   uint64
+	total   uint64
+	totals  *counterTotals
+}
+
 type counterChainer struct {
-	chainer
+	*counterEnvelope
 }
 
 func (c counterChainer) SetTag(key, value string) CounterChainer {
-	c.chainer = c.chainer.SetTag(key, value)
+	if err := c.setTag(key, value); err != nil {
+		return counterChainer{&counterEnvelope{pendingEnvelope: pendingEnvelope{err: err}}}
+	}
 	return c
 }
 
 func (c counterChainer) Add(delta uint64) error {
+	return c.AddContext(context.Background(), delta)
+}
+
+// AddContext is like Add, but the emitter gives up once ctx is done.
+func (c counterChainer) AddContext(ctx context.Context, delta uint64) error {
 	if c.err != nil {
 		return c.err
 	}
 
-	c.envelope.CounterEvent.Delta = proto.Uint64(delta)
-	return c.chainer.Send()
+	c.delta = delta
+	c.counter.Delta = &c.delta
+	c.applyLimit()
+	if c.totals != nil {
+		var tracked bool
+		if c.total, tracked = c.totals.add(c.name, c.envelope.Tags, delta); tracked {
+			c.counter.Total = &c.total
+			c.setStartTime()
+		}
+	}
+	return c.SendContext(ctx)
+}
+
+// setStartTime sets the StartTimeTag, unless the counter already carries the
+// maximum number of tags. Untagged counters share a single tag map, so that
+// sending them does not allocate one.
+func (c counterChainer) setStartTime() {
+	switch {
+	case len(c.envelope.Tags) == 0:
+		c.envelope.Tags = c.totals.startTags
+	case len(c.envelope.Tags) < maxTags:
+		c.envelope.Tags[StartTimeTag] = c.totals.startTime
+	default:
+		if _, ok := c.envelope.Tags[StartTimeTag]; ok {
+			c.envelope.Tags[StartTimeTag] = c.totals.startTime
+		}
+	}
 }
 
 func (c counterChainer) Increment() error {
+	return c.IncrementContext(context.Background())
+}
+
+// IncrementContext is like Increment, but the emitter gives up once ctx is
+// done.
+func (c counterChainer) IncrementContext(ctx context.Context) error {
 	if c.err != nil {
 		return c.err
 	}
 
-	return c.Add(1)
+	return c.AddContext(ctx, 1)
+}
+
+// counterTotals holds the running total of every counter sent since
+// startTime.
+type counterTotals struct {
+	startTime string
+	// startTags holds only the StartTimeTag. It is shared by the envelopes
+	// of untagged counters and must not be modified.
+	startTags map[string]string
+
+	lock   sync.Mutex
+	totals map[string]uint64
+}
+
+func newCounterTotals(start time.Time) *counterTotals {
+	startTime := strconv.FormatInt(start.UnixNano(), 10)
+	return &counterTotals{
+		startTime: startTime,
+		startTags: map[string]string{StartTimeTag: startTime},
+		totals:    make(map[string]uint64),
+	}
+}
+
+// add adds delta to the total of the counter with the given name and tags and
+// returns the new total. It returns false, and keeps no total, for a new
+// series once maxTrackedCounterSeries are tracked. The StartTimeTag is not
+// part of a counter's identity.
+func (t *counterTotals) add(name string, tags map[string]string, delta uint64) (uint64, bool) {
+	if _, ok := tags[StartTimeTag]; ok {
+		withoutStart := make(map[string]string, len(tags))
+		for k, v := range tags {
+			if k != StartTimeTag {
+				withoutStart[k] = v
+			}
+		}
+		tags = withoutStart
+	}
+	key := SeriesKey(name, tags)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	total, ok := t.totals[key]
+	if !ok && len(t.totals) >= maxTrackedCounterSeries {
+		return 0, false
+	}
+	total += delta
+	t.totals[key] = total
+	return total, true
+}
+
+// SeriesKey identifies a series by its name and tags, independent of the
+// order in which the tags were set.
+func SeriesKey(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteByte(0)
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(tags[k])
+	}
+	return b.String()
 }
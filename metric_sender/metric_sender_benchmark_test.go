@@ -0,0 +1,84 @@
+package metric_sender_test
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+)
+
+type discardByteEmitter struct{}
+
+func (discardByteEmitter) Emit([]byte) error { return nil }
+func (discardByteEmitter) NonRetaining()     {}
+func (discardByteEmitter) Close()            {}
+
+func newBenchmarkSender() *metric_sender.MetricSender {
+	return metric_sender.NewMetricSender(emitter.NewEventEmitter(discardByteEmitter{}, "origin"))
+}
+
+func BenchmarkMetricSenderValue(b *testing.B) {
+	sender := newBenchmarkSender()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.Value("metric-name", 1.5, "ms").Send(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMetricSenderValueWithTag(b *testing.B) {
+	sender := newBenchmarkSender()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.Value("metric-name", 1.5, "ms").SetTag("az", "z1").Send(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMetricSenderCounter(b *testing.B) {
+	sender := newBenchmarkSender()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.Counter("requests").Add(2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// maxCounterAllocs is the number of allocations sending an untagged counter
+// may cost: one for the envelope, and four for protobuf to encode the
+// start_time tag.
+const maxCounterAllocs = 5
+
+func TestMetricSenderCounterAllocs(t *testing.T) {
+	sender := newBenchmarkSender()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := sender.Counter("requests").Add(2); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > maxCounterAllocs {
+		t.Errorf("sending a counter allocated %v times, want at most %d", allocs, maxCounterAllocs)
+	}
+}
+
+func BenchmarkMetricSenderSendValue(b *testing.B) {
+	sender := newBenchmarkSender()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.SendValue("metric-name", 1.5, "ms"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
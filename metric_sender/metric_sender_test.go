@@ -1,13 +1,16 @@
 package metric_sender_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/units"
 	"github.com/cloudfoundry/sonde-go/events"
 	"google.golang.org/protobuf/proto"
 
@@ -116,6 +119,40 @@ var _ = Describe("MetricSender", func() {
 		})
 	})
 
+	Describe("SendContext", func() {
+		It("sends value metrics", func() {
+			chainer := sender.Value("foo", 1.2, "bar").SetTag("key", "value")
+			err := chainer.(metric_sender.ContextValueChainer).SendContext(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+		})
+
+		It("sends counters", func() {
+			counter := sender.Counter("requests").(metric_sender.ContextCounterChainer)
+			err := counter.IncrementContext(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			err = counter.AddContext(context.Background(), 3)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(emitter.GetEnvelopes()).To(HaveLen(2))
+			Expect(emitter.GetEnvelopes()[1].CounterEvent.GetDelta()).To(BeEquivalentTo(3))
+		})
+
+		It("does not send once the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			value := sender.Value("foo", 1.2, "bar").(metric_sender.ContextValueChainer)
+			Expect(value.SendContext(ctx)).To(MatchError(context.Canceled))
+			containerMetric := sender.ContainerMetric("app", 0, 1, 2, 3).(metric_sender.ContextContainerMetricChainer)
+			Expect(containerMetric.SendContext(ctx)).To(MatchError(context.Canceled))
+			counter := sender.Counter("requests").(metric_sender.ContextCounterChainer)
+			Expect(counter.AddContext(ctx, 1)).To(MatchError(context.Canceled))
+			Expect(emitter.GetEnvelopes()).To(BeEmpty())
+		})
+	})
+
 	Describe("ContainerMetric", func() {
 		It("sets the required properties", func() {
 			err := sender.ContainerMetric("test-app-id", 1234, 1.2, 2345, 3456).
@@ -130,6 +167,20 @@ var _ = Describe("MetricSender", func() {
 			Expect(metric.GetCpuPercentage()).To(Equal(1.2))
 			Expect(metric.GetMemoryBytes()).To(BeEquivalentTo(2345))
 			Expect(metric.GetDiskBytes()).To(BeEquivalentTo(3456))
+			Expect(metric.MemoryBytesQuota).To(BeNil())
+			Expect(metric.DiskBytesQuota).To(BeNil())
+		})
+
+		It("sets quotas", func() {
+			err := sender.ContainerMetric("test-app-id", 1234, 1.2, 2345, 3456).(metric_sender.QuotaContainerMetricChainer).
+				SetMemoryQuota(4567).
+				SetDiskQuota(5678).
+				Send()
+			Expect(err).ToNot(HaveOccurred())
+
+			metric := emitter.GetEnvelopes()[0].ContainerMetric
+			Expect(metric.GetMemoryBytesQuota()).To(BeEquivalentTo(4567))
+			Expect(metric.GetDiskBytesQuota()).To(BeEquivalentTo(5678))
 		})
 
 		Context("tags", func() {
@@ -309,6 +360,84 @@ var _ = Describe("MetricSender", func() {
 			})
 		})
 
+		Context("totals", func() {
+			It("sets the running total of each counter", func() {
+				Expect(sender.Counter("requests").Increment()).To(Succeed())
+				Expect(sender.Counter("requests").Add(3)).To(Succeed())
+				Expect(sender.AddToCounter("requests", 2)).To(Succeed())
+				Expect(sender.Counter("errors").Add(5)).To(Succeed())
+
+				var totals []uint64
+				for _, envelope := range emitter.GetEnvelopes() {
+					totals = append(totals, envelope.GetCounterEvent().GetTotal())
+				}
+				Expect(totals).To(Equal([]uint64{1, 4, 6, 5}))
+			})
+
+			It("keeps separate totals for counters with different tags", func() {
+				Expect(sender.Counter("requests").SetTag("a", "1").SetTag("b", "2").Add(2)).To(Succeed())
+				Expect(sender.Counter("requests").SetTag("a", "2").Add(3)).To(Succeed())
+				Expect(sender.Counter("requests").SetTag("b", "2").SetTag("a", "1").Add(4)).To(Succeed())
+
+				Expect(emitter.GetEnvelopes()).To(HaveLen(3))
+				Expect(emitter.GetEnvelopes()[0].GetCounterEvent().GetTotal()).To(BeEquivalentTo(2))
+				Expect(emitter.GetEnvelopes()[1].GetCounterEvent().GetTotal()).To(BeEquivalentTo(3))
+				Expect(emitter.GetEnvelopes()[2].GetCounterEvent().GetTotal()).To(BeEquivalentTo(6))
+			})
+
+			It("tags counters with the time totals started", func() {
+				before := time.Now().UnixNano()
+				sender = metric_sender.NewMetricSender(emitter)
+
+				Expect(sender.Counter("requests").Increment()).To(Succeed())
+				Expect(sender.Counter("requests").SetTag("foo", "bar").Increment()).To(Succeed())
+
+				Expect(emitter.GetEnvelopes()).To(HaveLen(2))
+				startTime := emitter.GetEnvelopes()[0].GetTags()[metric_sender.StartTimeTag]
+				Expect(strconv.ParseInt(startTime, 10, 64)).To(BeNumerically(">=", before))
+				Expect(emitter.GetEnvelopes()[1].GetTags()).To(HaveKeyWithValue(metric_sender.StartTimeTag, startTime))
+			})
+
+			It("resets totals in a new sender", func() {
+				Expect(sender.Counter("requests").Add(3)).To(Succeed())
+				time.Sleep(time.Millisecond)
+				sender = metric_sender.NewMetricSender(emitter)
+				Expect(sender.Counter("requests").Add(2)).To(Succeed())
+
+				first, second := emitter.GetEnvelopes()[0], emitter.GetEnvelopes()[1]
+				Expect(second.GetCounterEvent().GetTotal()).To(BeEquivalentTo(2))
+				Expect(second.GetTags()[metric_sender.StartTimeTag]).ToNot(Equal(first.GetTags()[metric_sender.StartTimeTag]))
+			})
+
+			It("leaves out the start time tag when the counter has the maximum number of tags", func() {
+				c := sender.Counter("requests")
+				for i := 0; i < 10; i++ {
+					c = c.SetTag(fmt.Sprintf("key-%d", i), "value")
+				}
+				Expect(c.Increment()).To(Succeed())
+
+				envelope := emitter.GetEnvelopes()[0]
+				Expect(envelope.GetTags()).To(HaveLen(10))
+				Expect(envelope.GetTags()).ToNot(HaveKey(metric_sender.StartTimeTag))
+				Expect(envelope.GetCounterEvent().GetTotal()).To(BeEquivalentTo(1))
+			})
+
+			It("stops keeping totals for new series beyond the tracking limit", func() {
+				for i := 0; i < 1<<16; i++ {
+					Expect(sender.Counter("requests").SetTag("id", strconv.Itoa(i)).Increment()).To(Succeed())
+				}
+				emitter.Reset()
+
+				Expect(sender.Counter("requests").SetTag("id", "new").Add(2)).To(Succeed())
+				Expect(sender.Counter("requests").SetTag("id", "0").Add(2)).To(Succeed())
+
+				untracked, tracked := emitter.GetEnvelopes()[0], emitter.GetEnvelopes()[1]
+				Expect(untracked.GetCounterEvent().Total).To(BeNil())
+				Expect(untracked.GetTags()).ToNot(HaveKey(metric_sender.StartTimeTag))
+				Expect(tracked.GetCounterEvent().GetTotal()).To(BeEquivalentTo(3))
+			})
+		})
+
 		It("sets origin", func() {
 			err := sender.Counter("requests").Increment()
 			Expect(err).ToNot(HaveOccurred())
@@ -331,6 +460,223 @@ var _ = Describe("MetricSender", func() {
 		})
 	})
 
+	Describe("default tags", func() {
+		BeforeEach(func() {
+			Expect(sender.SetDefaultTags(map[string]string{"az": "z1", "env": "prod"})).To(Succeed())
+		})
+
+		It("adds them to every metric", func() {
+			Expect(sender.SendValue("latency", 1, "ms")).To(Succeed())
+			Expect(sender.IncrementCounter("requests")).To(Succeed())
+			Expect(sender.SendContainerMetric("app", 0, 1, 2, 3)).To(Succeed())
+			Expect(sender.Value("latency", 1, "ms").Send()).To(Succeed())
+			Expect(sender.Counter("requests").SetTag("route", "/").Add(2)).To(Succeed())
+
+			Expect(emitter.GetEnvelopes()).To(HaveLen(5))
+			for _, envelope := range emitter.GetEnvelopes() {
+				Expect(envelope.GetTags()).To(HaveKeyWithValue("az", "z1"))
+				Expect(envelope.GetTags()).To(HaveKeyWithValue("env", "prod"))
+			}
+			Expect(emitter.GetEnvelopes()[4].GetTags()).To(HaveKeyWithValue("route", "/"))
+		})
+
+		It("lets chained tags override them", func() {
+			Expect(sender.Value("latency", 1, "ms").SetTag("env", "staging").Send()).To(Succeed())
+
+			Expect(emitter.GetEnvelopes()[0].GetTags()).To(HaveKeyWithValue("env", "staging"))
+		})
+
+		It("does not share tags between metrics", func() {
+			Expect(sender.Value("latency", 1, "ms").SetTag("route", "/").Send()).To(Succeed())
+			Expect(sender.Value("latency", 1, "ms").Send()).To(Succeed())
+
+			Expect(emitter.GetEnvelopes()[1].GetTags()).ToNot(HaveKey("route"))
+		})
+
+		It("counts them against the tag limit", func() {
+			c := sender.Value("latency", 1, "ms")
+			for i := 0; i < 9; i++ {
+				c = c.SetTag(fmt.Sprintf("key-%d", i), "value")
+			}
+			Expect(c.Send()).To(MatchError("Too many tags. Max of 10"))
+		})
+
+		It("rejects invalid default tags", func() {
+			tooMany := make(map[string]string)
+			for i := 0; i < 11; i++ {
+				tooMany[fmt.Sprintf("key-%d", i)] = "value"
+			}
+			Expect(sender.SetDefaultTags(tooMany)).ToNot(Succeed())
+			Expect(sender.SetDefaultTags(map[string]string{"key": strings.Repeat("x", 257)})).ToNot(Succeed())
+
+			Expect(sender.SendValue("latency", 1, "ms")).To(Succeed())
+			Expect(emitter.GetEnvelopes()[0].GetTags()).To(HaveKeyWithValue("az", "z1"))
+		})
+	})
+
+	Describe("cardinality limits", func() {
+		var routes = func(name string) []string {
+			var values []string
+			for _, envelope := range emitter.GetEnvelopes() {
+				if envelope.GetCounterEvent().GetName() == name || envelope.GetValueMetric().GetName() == name {
+					values = append(values, envelope.GetTags()["route"])
+				}
+			}
+			return values
+		}
+
+		var overflowed = func(metric string) uint64 {
+			var total uint64
+			for _, envelope := range emitter.GetEnvelopes() {
+				if envelope.GetCounterEvent().GetName() == metric_sender.OverflowCounterName &&
+					envelope.GetTags()[metric_sender.OverflowMetricTag] == metric {
+					total += envelope.GetCounterEvent().GetDelta()
+				}
+			}
+			return total
+		}
+
+		It("folds series beyond the limit into the overflow tag value", func() {
+			sender.SetCardinalityLimit("requests", 2)
+
+			for _, route := range []string{"/a", "/b", "/c", "/a", "/d", "/c"} {
+				Expect(sender.Counter("requests").SetTag("route", route).Increment()).To(Succeed())
+			}
+
+			Expect(routes("requests")).To(Equal([]string{"/a", "/b", "__overflow__", "/a", "__overflow__", "__overflow__"}))
+			Expect(overflowed("requests")).To(BeEquivalentTo(2))
+		})
+
+		It("keeps totals for the overflow series", func() {
+			sender.SetCardinalityLimit("requests", 1)
+
+			Expect(sender.Counter("requests").SetTag("route", "/a").Add(1)).To(Succeed())
+			Expect(sender.Counter("requests").SetTag("route", "/b").Add(2)).To(Succeed())
+			Expect(sender.Counter("requests").SetTag("route", "/c").Add(3)).To(Succeed())
+
+			var total uint64
+			for _, envelope := range emitter.GetEnvelopes() {
+				if envelope.GetCounterEvent().GetName() == "requests" {
+					total = envelope.GetCounterEvent().GetTotal()
+				}
+			}
+			Expect(total).To(BeEquivalentTo(5))
+		})
+
+		It("applies the default limit to value metrics", func() {
+			sender.SetDefaultCardinalityLimit(1)
+
+			Expect(sender.Value("latency", 1, "ms").SetTag("route", "/a").Send()).To(Succeed())
+			Expect(sender.Value("latency", 1, "ms").SetTag("route", "/b").Send()).To(Succeed())
+			Expect(sender.Value("latency", 1, "ms").Send()).To(Succeed())
+
+			Expect(routes("latency")).To(Equal([]string{"/a", "__overflow__", ""}))
+			Expect(overflowed("latency")).To(BeEquivalentTo(1))
+		})
+
+		It("lets a metric's own limit override the default", func() {
+			sender.SetDefaultCardinalityLimit(1)
+			sender.SetCardinalityLimit("requests", 0)
+
+			Expect(sender.Counter("requests").SetTag("route", "/a").Increment()).To(Succeed())
+			Expect(sender.Counter("requests").SetTag("route", "/b").Increment()).To(Succeed())
+
+			Expect(routes("requests")).To(Equal([]string{"/a", "/b"}))
+		})
+	})
+
+	Describe("SetUnitMode", func() {
+		var unit = func() string {
+			return emitter.GetEnvelopes()[0].GetValueMetric().GetUnit()
+		}
+
+		It("sends units unchecked by default", func() {
+			Expect(sender.Value("latency", 1, "milliseconds").Send()).To(Succeed())
+
+			Expect(unit()).To(Equal("milliseconds"))
+		})
+
+		It("normalizes known units in permissive mode", func() {
+			sender.SetUnitMode(units.Permissive)
+
+			Expect(sender.SendValue("latency", 1, "milliseconds")).To(Succeed())
+			Expect(sender.Value("size", 1, "furlongs").Send()).To(Succeed())
+
+			Expect(unit()).To(Equal(units.Milliseconds))
+			Expect(emitter.GetEnvelopes()[1].GetValueMetric().GetUnit()).To(Equal("furlongs"))
+		})
+
+		It("rejects unknown units in strict mode", func() {
+			sender.SetUnitMode(units.Strict)
+
+			Expect(sender.Value("size", 1, "B").SetTag("a", "b").Send()).To(Succeed())
+			Expect(sender.Value("size", 1, "furlongs").SetTag("a", "b").Send()).To(MatchError(`Unknown unit "furlongs"`))
+
+			Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+			Expect(unit()).To(Equal(units.Bytes))
+		})
+	})
+
+	Describe("SuppressUnchangedValues", func() {
+		var sent = func() []float64 {
+			var values []float64
+			for _, envelope := range emitter.GetEnvelopes() {
+				values = append(values, envelope.GetValueMetric().GetValue())
+			}
+			return values
+		}
+
+		It("drops values equal to the last one sent for the series", func() {
+			sender.SuppressUnchangedValues(time.Hour)
+
+			for _, value := range []float64{1, 1, 2, 2, 1} {
+				Expect(sender.Value("cells", value, "count").Send()).To(Succeed())
+			}
+
+			Expect(sent()).To(Equal([]float64{1, 2, 1}))
+		})
+
+		It("tells series apart by their tags", func() {
+			sender.SuppressUnchangedValues(time.Hour)
+
+			Expect(sender.Value("cells", 1, "count").SetTag("zone", "z1").Send()).To(Succeed())
+			Expect(sender.Value("cells", 1, "count").SetTag("zone", "z2").Send()).To(Succeed())
+			Expect(sender.Value("cells", 1, "count").SetTag("zone", "z1").Send()).To(Succeed())
+
+			Expect(sent()).To(Equal([]float64{1, 1}))
+		})
+
+		It("re-sends an unchanged value after the max silence", func() {
+			sender.SuppressUnchangedValues(50 * time.Millisecond)
+
+			Expect(sender.Value("cells", 1, "count").Send()).To(Succeed())
+			Expect(sender.Value("cells", 1, "count").Send()).To(Succeed())
+			Expect(sent()).To(HaveLen(1))
+
+			time.Sleep(60 * time.Millisecond)
+			Expect(sender.Value("cells", 1, "count").Send()).To(Succeed())
+			Expect(sent()).To(HaveLen(2))
+		})
+
+		It("re-sends a value that failed to send", func() {
+			sender.SuppressUnchangedValues(time.Hour)
+			emitter.ReturnError = errors.New("send failed")
+
+			Expect(sender.Value("cells", 1, "count").Send()).To(MatchError("send failed"))
+			Expect(sender.Value("cells", 1, "count").Send()).To(Succeed())
+			Expect(sent()).To(Equal([]float64{1}))
+		})
+
+		It("sends every value once turned off", func() {
+			sender.SuppressUnchangedValues(time.Hour)
+			Expect(sender.Value("cells", 1, "count").Send()).To(Succeed())
+			sender.SuppressUnchangedValues(0)
+			Expect(sender.Value("cells", 1, "count").Send()).To(Succeed())
+
+			Expect(sent()).To(Equal([]float64{1, 1}))
+		})
+	})
+
 	Describe("Send", func() {
 		It("sends an event to its emitter", func() {
 			err := sender.Send(&events.ValueMetric{
@@ -361,8 +707,8 @@ var _ = Describe("MetricSender", func() {
 			err := sender.SendValue("metric-name", 42, "answers")
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(emitter.GetMessages()).To(HaveLen(1))
-			metric := emitter.GetMessages()[0].Event.(*events.ValueMetric)
+			Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+			metric := emitter.GetEnvelopes()[0].GetValueMetric()
 			Expect(metric.GetName()).To(Equal("metric-name"))
 			Expect(metric.GetValue()).To(BeNumerically("==", 42))
 			Expect(metric.GetUnit()).To(Equal("answers"))
@@ -372,7 +718,7 @@ var _ = Describe("MetricSender", func() {
 			emitter.ReturnError = errors.New("some error")
 
 			err := sender.SendValue("stuff", 12, "no answer")
-			Expect(emitter.GetMessages()).To(HaveLen(0))
+			Expect(emitter.GetEnvelopes()).To(HaveLen(0))
 			Expect(err.Error()).To(Equal("some error"))
 		})
 	})
@@ -382,8 +728,8 @@ var _ = Describe("MetricSender", func() {
 			err := sender.IncrementCounter("counter-strike")
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(emitter.GetMessages()).To(HaveLen(1))
-			counterEvent := emitter.GetMessages()[0].Event.(*events.CounterEvent)
+			Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+			counterEvent := emitter.GetEnvelopes()[0].GetCounterEvent()
 			Expect(counterEvent.GetName()).To(Equal("counter-strike"))
 			Expect(counterEvent.GetDelta()).To(Equal(uint64(1)))
 		})
@@ -392,7 +738,7 @@ var _ = Describe("MetricSender", func() {
 			emitter.ReturnError = errors.New("some counter event error")
 
 			err := sender.IncrementCounter("count me in")
-			Expect(emitter.GetMessages()).To(HaveLen(0))
+			Expect(emitter.GetEnvelopes()).To(HaveLen(0))
 			Expect(err.Error()).To(Equal("some counter event error"))
 		})
 	})
@@ -402,8 +748,8 @@ var _ = Describe("MetricSender", func() {
 			err := sender.AddToCounter("counter-strike", 3)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(emitter.GetMessages()).To(HaveLen(1))
-			counterEvent := emitter.GetMessages()[0].Event.(*events.CounterEvent)
+			Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+			counterEvent := emitter.GetEnvelopes()[0].GetCounterEvent()
 			Expect(counterEvent.GetName()).To(Equal("counter-strike"))
 			Expect(counterEvent.GetDelta()).To(Equal(uint64(3)))
 		})
@@ -414,8 +760,8 @@ var _ = Describe("MetricSender", func() {
 			err := sender.SendContainerMetric("some_app_guid", 0, 42.42, 1234, 123412341234)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(emitter.GetMessages()).To(HaveLen(1))
-			containerMetric := emitter.GetMessages()[0].Event.(*events.ContainerMetric)
+			Expect(emitter.GetEnvelopes()).To(HaveLen(1))
+			containerMetric := emitter.GetEnvelopes()[0].GetContainerMetric()
 
 			Expect(containerMetric.GetApplicationId()).To(Equal("some_app_guid"))
 			Expect(containerMetric.GetInstanceIndex()).To(Equal(int32(0)))
@@ -430,7 +776,7 @@ var _ = Describe("MetricSender", func() {
 			emitter.ReturnError = errors.New("some container metric error")
 
 			err := sender.SendContainerMetric("some_app_guid", 0, 42.42, 1234, 123412341234)
-			Expect(emitter.GetMessages()).To(HaveLen(0))
+			Expect(emitter.GetEnvelopes()).To(HaveLen(0))
 			Expect(err.Error()).To(Equal("some container metric error"))
 		})
 	})
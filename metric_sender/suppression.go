@@ -0,0 +1,69 @@
+package metric_sender
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTrackedValueSeries bounds the memory used to remember the last value of
+// each series. Beyond it, values of new series are always sent.
+const maxTrackedValueSeries = 1 << 16
+
+// valueSuppressor drops ValueMetrics that repeat the last value sent for
+// their series, until the series has been silent for maxSilence.
+type valueSuppressor struct {
+	// maxSilence is in nanoseconds. It is read without the lock, so that
+	// senders without suppression never take it, and 0 disables suppression.
+	maxSilence int64
+
+	lock sync.Mutex
+	last map[string]lastValue
+}
+
+type lastValue struct {
+	value  float64
+	sentAt time.Time
+}
+
+func newValueSuppressor() *valueSuppressor {
+	return &valueSuppressor{last: make(map[string]lastValue)}
+}
+
+func (s *valueSuppressor) setMaxSilence(maxSilence time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.StoreInt64(&s.maxSilence, int64(maxSilence))
+	if maxSilence <= 0 {
+		s.last = make(map[string]lastValue)
+	}
+}
+
+// suppress reports whether value should be dropped for the series with the
+// given name and tags. Unless suppression is disabled, it also returns the
+// key under which a sent value must be recorded.
+func (s *valueSuppressor) suppress(name string, tags map[string]string, value float64, now time.Time) (string, bool) {
+	maxSilence := time.Duration(atomic.LoadInt64(&s.maxSilence))
+	if maxSilence <= 0 {
+		return "", false
+	}
+
+	key := SeriesKey(name, tags)
+	s.lock.Lock()
+	last, ok := s.last[key]
+	s.lock.Unlock()
+	return key, ok && last.value == value && now.Sub(last.sentAt) < maxSilence
+}
+
+// sent records that value was sent for the series with the given key.
+func (s *valueSuppressor) sent(key string, value float64, now time.Time) {
+	if key == "" {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.last[key]; ok || len(s.last) < maxTrackedValueSeries {
+		s.last[key] = lastValue{value: value, sentAt: now}
+	}
+}
@@ -0,0 +1,27 @@
+package metricbatcher
+
+import (
+	"sort"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+)
+
+// overflowCounters returns, for a window that ended, a counter of the series
+// folded for each metric.
+func overflowCounters(folded map[string]int) []*counterSeries {
+	names := make([]string, 0, len(folded))
+	for name := range folded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	overflow := make([]*counterSeries, 0, len(names))
+	for _, name := range names {
+		overflow = append(overflow, &counterSeries{
+			value: uint64(folded[name]),
+			name:  metric_sender.OverflowCounterName,
+			tags:  map[string]string{metric_sender.OverflowMetricTag: name},
+		})
+	}
+	return overflow
+}
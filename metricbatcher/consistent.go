@@ -0,0 +1,182 @@
+package metricbatcher
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+)
+
+// consistentSeries is a series that is sent on every flush, whether or not
+// it was updated.
+type consistentSeries struct {
+	seq  uint64
+	key  string
+	name string
+	unit string
+	tags map[string]string
+	// last is the value most recently sent for a gauge.
+	last float64
+}
+
+// consistentlyEmitted holds the metrics that are sent on every flush.
+type consistentlyEmitted struct {
+	lock     sync.RWMutex
+	names    []string
+	counters map[string]*consistentSeries
+	gauges   map[string]*consistentSeries
+}
+
+func newConsistentlyEmitted() *consistentlyEmitted {
+	return &consistentlyEmitted{
+		counters: make(map[string]*consistentSeries),
+		gauges:   make(map[string]*consistentSeries),
+	}
+}
+
+// AddConsistentlyEmittedMetrics makes the batcher send the named counters on
+// every flush. A zero delta is sent for each tag set a counter was sent with
+// in the previous flush, or for the untagged counter if there were none.
+func (mb *MetricBatcher) AddConsistentlyEmittedMetrics(names ...string) {
+	mb.consistent.lock.Lock()
+	defer mb.consistent.lock.Unlock()
+
+	mb.consistent.names = append(mb.consistent.names, names...)
+}
+
+// RemoveConsistentlyEmittedMetrics undoes AddConsistentlyEmittedMetrics for
+// the named counters.
+func (mb *MetricBatcher) RemoveConsistentlyEmittedMetrics(names ...string) {
+	mb.consistent.lock.Lock()
+	defer mb.consistent.lock.Unlock()
+
+	kept := mb.consistent.names[:0]
+	for _, n := range mb.consistent.names {
+		if !contains(names, n) {
+			kept = append(kept, n)
+		}
+	}
+	mb.consistent.names = kept
+}
+
+// AddConsistentlyEmittedCounter makes the batcher send the counter with the
+// given name and tags on every flush, with a zero delta if it was not
+// updated.
+func (mb *MetricBatcher) AddConsistentlyEmittedCounter(name string, tags map[string]string) {
+	mb.consistent.add(mb.consistent.counters, mb.nextSeq(), name, "", tags)
+}
+
+// AddConsistentlyEmittedGauge makes the batcher send the gauge with the given
+// name and tags on every flush. If it was not set, the value last sent is
+// sent again, or zero if it has never been set.
+func (mb *MetricBatcher) AddConsistentlyEmittedGauge(name, unit string, tags map[string]string) {
+	mb.consistent.add(mb.consistent.gauges, mb.nextSeq(), name, unit, tags)
+}
+
+// RemoveConsistentlyEmittedCounter stops the counter with the given name and
+// tags from being sent when it is not updated.
+func (mb *MetricBatcher) RemoveConsistentlyEmittedCounter(name string, tags map[string]string) {
+	mb.consistent.remove(mb.consistent.counters, name, tags)
+}
+
+// RemoveConsistentlyEmittedGauge stops the gauge with the given name and tags
+// from being sent when it is not set.
+func (mb *MetricBatcher) RemoveConsistentlyEmittedGauge(name string, tags map[string]string) {
+	mb.consistent.remove(mb.consistent.gauges, name, tags)
+}
+
+func (c *consistentlyEmitted) add(series map[string]*consistentSeries, seq uint64, name, unit string, tags map[string]string) {
+	key := metric_sender.SeriesKey(name, tags)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if existing, ok := series[key]; ok {
+		existing.unit = unit
+		return
+	}
+	series[key] = &consistentSeries{seq: seq, key: key, name: name, unit: unit, tags: copyTags(tags)}
+}
+
+func (c *consistentlyEmitted) remove(series map[string]*consistentSeries, name string, tags map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(series, metric_sender.SeriesKey(name, tags))
+}
+
+// fill appends to the series of a flush window the consistently emitted
+// counters and gauges that were not updated in it, and records the values
+// sent for gauges. window returns the shard of the window a key belongs to.
+func (c *consistentlyEmitted) fill(counters []*counterSeries, gauges []*gaugeSeries, window func(key string) *shard) ([]*counterSeries, []*gaugeSeries) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, s := range sortedSeries(c.counters) {
+		if _, ok := window(s.key).counters[s.key]; !ok {
+			counters = append(counters, &counterSeries{seq: s.seq, name: s.name, tags: s.tags})
+		}
+	}
+
+	for _, s := range sortedSeries(c.gauges) {
+		if gauge, ok := window(s.key).gauges[s.key]; ok {
+			s.last = gauge.result()
+			continue
+		}
+		gauges = append(gauges, &gaugeSeries{
+			seq:   s.seq,
+			name:  s.name,
+			unit:  s.unit,
+			tags:  s.tags,
+			value: s.last,
+			count: 1,
+		})
+	}
+
+	return counters, gauges
+}
+
+// seedConsistentlyEmittedMetrics batches a zero delta for every consistently
+// emitted metric, once for each tag set it was last sent with, so that they
+// are sent in the next flush even if they are not updated.
+func (mb *MetricBatcher) seedConsistentlyEmittedMetrics(previous []*counterSeries) {
+	mb.consistent.lock.RLock()
+	defer mb.consistent.lock.RUnlock()
+
+	names := mb.consistent.names
+	if len(names) == 0 {
+		return
+	}
+
+	matched := make(map[string]struct{})
+	for _, counter := range previous {
+		if contains(names, counter.name) {
+			matched[counter.name] = struct{}{}
+			mb.add(counter.name, counter.tags, 0)
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := matched[name]; !ok {
+			mb.add(name, nil, 0)
+		}
+	}
+}
+
+func sortedSeries(series map[string]*consistentSeries) []*consistentSeries {
+	sorted := make([]*consistentSeries, 0, len(series))
+	for _, s := range series {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+	return sorted
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
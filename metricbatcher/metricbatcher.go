@@ -1,8 +1,11 @@
-// package metricbatcher provides a mechanism to batch counter updates into a single event.
+// package metricbatcher provides a mechanism to batch counter and gauge updates into a single event.
 package metricbatcher
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/metric_sender"
@@ -14,44 +17,98 @@ type MetricSender interface {
 	Counter(name string) metric_sender.CounterChainer
 }
 
-type batch struct {
+// ValueSender is implemented by MetricSenders that batched gauges can be sent
+// through, such as *metric_sender.MetricSender. Gauges batched for a
+// MetricSender that does not implement it are dropped.
+type ValueSender interface {
+	Value(name string, value float64, unit string) metric_sender.ValueChainer
+}
+
+// GaugeAggregation selects how the values set on a batched gauge during a
+// flush window are combined into the single value that is sent.
+type GaugeAggregation int
+
+const (
+	// GaugeLast sends the most recently set value.
+	GaugeLast GaugeAggregation = iota
+	// GaugeMin sends the smallest value set.
+	GaugeMin
+	// GaugeMax sends the largest value set.
+	GaugeMax
+	// GaugeMean sends the arithmetic mean of the values set.
+	GaugeMean
+)
+
+// shardCount is the number of independently locked partitions series are
+// spread over, so that updates to different series rarely contend.
+const shardCount = 32
+
+type counterSeries struct {
+	// value is first so that it is 64-bit aligned for atomic access.
+	value uint64
+	seq   uint64
 	name  string
 	tags  map[string]string
-	value uint64
 }
 
-// MetricBatcher batches counter increment/add calls into periodic, aggregate events.
+type gaugeSeries struct {
+	lock        sync.Mutex
+	seq         uint64
+	name        string
+	unit        string
+	tags        map[string]string
+	aggregation GaugeAggregation
+	value       float64
+	count       uint64
+}
+
+// shard holds the series whose keys hash to it. Existing series are updated
+// under the read lock; the write lock is only taken to add a series or to
+// swap the maps out for a flush.
+type shard struct {
+	lock     sync.RWMutex
+	counters map[string]*counterSeries
+	gauges   map[string]*gaugeSeries
+}
+
+// MetricBatcher batches counter increment/add calls and gauge updates into
+// periodic, aggregate events.
 type MetricBatcher struct {
-	metrics                        []batch
-	batchTicker                    *time.Ticker
-	metricSender                   MetricSender
-	lock                           sync.Mutex
-	closed                         bool
-	closedChan                     chan struct{}
-	consistentlyEmittedMetricNames []string
+	// seq orders series by when they were first batched, so that they are
+	// sent in that order. It is first so that it is 64-bit aligned for atomic
+	// access.
+	seq    uint64
+	closed int32
+
+	shards       [shardCount]shard
+	metricSender MetricSender
+	closedChan   chan struct{}
+	cardinality  *metric_sender.CardinalityLimiter
+	consistent   *consistentlyEmitted
 }
 
 // New instantiates a running MetricBatcher. Eventswill be emitted once per batchDuration. All
 // updates to a given counter name will be combined into a single event and sent to metricSender.
-func New(metricSender MetricSender, batchDuration time.Duration) *MetricBatcher {
+// Options change when flushes happen; see WithAlignment and WithJitter. New panics if
+// batchDuration is not positive.
+func New(metricSender MetricSender, batchDuration time.Duration, opts ...Option) *MetricBatcher {
+	if batchDuration <= 0 {
+		panic("metricbatcher: non-positive batch duration")
+	}
+
 	mb := &MetricBatcher{
-		batchTicker:  time.NewTicker(batchDuration),
 		metricSender: metricSender,
-		closed:       false,
 		closedChan:   make(chan struct{}),
+		cardinality:  metric_sender.NewCardinalityLimiter(),
+		consistent:   newConsistentlyEmitted(),
+	}
+	for i := range mb.shards {
+		mb.shards[i].counters = make(map[string]*counterSeries)
+		mb.shards[i].gauges = make(map[string]*gaugeSeries)
 	}
 
-	go func() {
-		for {
-			select {
-			case <-mb.batchTicker.C:
-				mb.flush(mb.resetAndReturnMetrics())
-			case <-mb.closedChan:
-				mb.batchTicker.Stop()
-				return
-			}
-		}
-	}()
+	s := newSchedule(batchDuration, time.Now(), opts)
+	go mb.run(s)
 
 	return mb
 }
@@ -65,38 +122,50 @@ func (mb *MetricBatcher) BatchIncrementCounter(name string) {
 // BatchAddCounter increments the named counter by the provided delta, but does not
 // immediately send a CounterEvent.
 func (mb *MetricBatcher) BatchAddCounter(name string, delta uint64) {
-	mb.lock.Lock()
-	defer mb.lock.Unlock()
-
-	if mb.closed {
+	if !mb.add(name, nil, delta) {
 		panic("Attempting to send metrics after closed")
 	}
-
-	mb.add(batch{name: name, value: delta})
 }
 
-func (mb *MetricBatcher) add(newBatch batch) {
-	for i, batch := range mb.metrics {
-		if batch.name != newBatch.name {
-			continue
-		}
-		if len(batch.tags) != len(newBatch.tags) {
-			continue
-		}
-		tagsMatch := true
-		for k, v := range batch.tags {
-			if newBatch.tags[k] != v {
-				tagsMatch = false
-				break
-			}
-		}
-		if !tagsMatch {
-			continue
-		}
-		mb.metrics[i].value += newBatch.value
-		return
+// add batches delta for the counter series. It returns false, and batches
+// nothing, once the batcher is closed. closed is checked under the shard lock,
+// so that an update either lands before Close swaps the shard out and is
+// flushed, or is refused.
+func (mb *MetricBatcher) add(name string, tags map[string]string, delta uint64) bool {
+	key := metric_sender.SeriesKey(name, tags)
+	s := mb.shardFor(key)
+
+	s.lock.RLock()
+	if mb.isClosed() {
+		s.lock.RUnlock()
+		return false
+	}
+	counter, ok := s.counters[key]
+	if ok {
+		atomic.AddUint64(&counter.value, delta)
+	}
+	s.lock.RUnlock()
+	if ok {
+		return true
 	}
-	mb.metrics = append(mb.metrics, newBatch)
+
+	if overflow, _ := mb.cardinality.Limit(name, tags); overflow != nil {
+		return mb.add(name, overflow, delta)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if mb.isClosed() {
+		return false
+	}
+	counter, ok = s.counters[key]
+	if !ok {
+		counter = &counterSeries{seq: mb.nextSeq(), name: name, tags: copyTags(tags)}
+		s.counters[key] = counter
+	}
+	atomic.AddUint64(&counter.value, delta)
+	return true
 }
 
 // BatchCounter returns a BatchCounterChainer which can be used to prepare
@@ -111,73 +180,249 @@ func (mb *MetricBatcher) BatchCounter(name string) BatchCounterChainer {
 
 // Reset clears the MetricBatcher's internal state, so that no counters are tracked.
 func (mb *MetricBatcher) Reset() {
-	mb.resetAndReturnMetrics()
+	mb.swap()
 }
 
 // Closes the metrics batcher. Using the batcher after closing, will cause a panic.
 func (mb *MetricBatcher) Close() {
-	mb.lock.Lock()
-	defer mb.lock.Unlock()
-
-	mb.closed = true
+	if !atomic.CompareAndSwapInt32(&mb.closed, 0, 1) {
+		return
+	}
 	close(mb.closedChan)
 
-	mb.flush(mb.unsafeResetAndReturnMetrics())
+	mb.flush(mb.swap())
 }
 
-func (mb *MetricBatcher) flush(metrics []batch) {
-	for _, metric := range metrics {
+// Flush immediately sends every batched counter and gauge, and starts a new
+// flush window. It returns an error if any of them could not be sent.
+func (mb *MetricBatcher) Flush() error {
+	return mb.flush(mb.swap())
+}
+
+func (mb *MetricBatcher) flush(counters []*counterSeries, gauges []*gaugeSeries) error {
+	var (
+		firstErr error
+		failed   int
+	)
+	record := func(err error) {
+		if err == nil {
+			return
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed++
+	}
+
+	for _, metric := range counters {
 		counter := mb.metricSender.Counter(metric.name)
 		for k, v := range metric.tags {
-			counter.SetTag(k, v)
+			counter = counter.SetTag(k, v)
+		}
+		record(counter.Add(atomic.LoadUint64(&metric.value)))
+	}
+
+	valueSender, ok := mb.metricSender.(ValueSender)
+	if !ok {
+		gauges = nil
+	}
+	for _, gauge := range gauges {
+		value := valueSender.Value(gauge.name, gauge.result(), gauge.unit)
+		for k, v := range gauge.tags {
+			value = value.SetTag(k, v)
+		}
+		record(value.Send())
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("metricbatcher: failed to send %d of %d metrics: %w", failed, len(counters)+len(gauges), firstErr)
+	}
+	return nil
+}
+
+// swap replaces every shard's series with empty maps and returns the series
+// that were batched, in the order they were first batched. Shards are only
+// locked while their maps are replaced, so batching continues while the
+// returned series are sent.
+func (mb *MetricBatcher) swap() ([]*counterSeries, []*gaugeSeries) {
+	var (
+		counters []*counterSeries
+		gauges   []*gaugeSeries
+		window   [shardCount]shard
+	)
+	for i := range mb.shards {
+		s := &mb.shards[i]
+		s.lock.Lock()
+		for _, counter := range s.counters {
+			counters = append(counters, counter)
+		}
+		for _, gauge := range s.gauges {
+			gauges = append(gauges, gauge)
 		}
-		counter.Add(metric.value)
+		window[i].counters, window[i].gauges = s.counters, s.gauges
+		s.counters = make(map[string]*counterSeries, len(s.counters))
+		s.gauges = make(map[string]*gaugeSeries, len(s.gauges))
+		s.lock.Unlock()
 	}
+
+	sort.Slice(counters, func(i, j int) bool { return counters[i].seq < counters[j].seq })
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].seq < gauges[j].seq })
+
+	overflow := overflowCounters(mb.cardinality.Reset())
+	counters, gauges = mb.consistent.fill(counters, gauges, func(key string) *shard {
+		return &window[shardIndex(key)]
+	})
+	mb.seedConsistentlyEmittedMetrics(counters)
+
+	return append(counters, overflow...), gauges
 }
 
-func (mb *MetricBatcher) resetAndReturnMetrics() []batch {
-	mb.lock.Lock()
-	defer mb.lock.Unlock()
+// BatchSetGauge sets the named gauge, but does not immediately send a
+// ValueMetric. Only the last value set during each flush window is sent.
+func (mb *MetricBatcher) BatchSetGauge(name string, value float64, unit string) {
+	if !mb.setGauge(name, unit, nil, GaugeLast, value) {
+		panic("Attempting to send metrics after closed")
+	}
+}
 
-	return mb.unsafeResetAndReturnMetrics()
+// BatchGauge returns a BatchGaugeChainer which can be used to prepare a gauge
+// before batching it up. The values set during each flush window are
+// combined according to aggregation.
+func (mb *MetricBatcher) BatchGauge(name, unit string, aggregation GaugeAggregation) BatchGaugeChainer {
+	return batchGaugeChainer{
+		batcher:     mb,
+		name:        name,
+		unit:        unit,
+		aggregation: aggregation,
+		tags:        make(map[string]string),
+	}
 }
 
-func (mb *MetricBatcher) unsafeResetAndReturnMetrics() []batch {
-	localMetrics := mb.metrics
-	mb.metrics = make([]batch, 0, len(mb.metrics))
+// setGauge batches value for the gauge series. Like add, it returns false,
+// and batches nothing, once the batcher is closed.
+func (mb *MetricBatcher) setGauge(name, unit string, tags map[string]string, aggregation GaugeAggregation, value float64) bool {
+	key := metric_sender.SeriesKey(name, tags)
+	s := mb.shardFor(key)
+
+	s.lock.RLock()
+	if mb.isClosed() {
+		s.lock.RUnlock()
+		return false
+	}
+	gauge, ok := s.gauges[key]
+	if ok {
+		gauge.set(value)
+	}
+	s.lock.RUnlock()
+	if ok {
+		return true
+	}
+
+	if overflow, _ := mb.cardinality.Limit(name, tags); overflow != nil {
+		return mb.setGauge(name, unit, overflow, aggregation, value)
+	}
 
-	matched := make(map[string]struct{})
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	for _, previousMetric := range localMetrics {
-		for _, name := range mb.consistentlyEmittedMetricNames {
-			if previousMetric.name == name {
-				matched[name] = struct{}{}
-				mb.metrics = append(mb.metrics, batch{
-					name:  name,
-					value: 0,
-					tags:  previousMetric.tags,
-				})
-			}
+	if mb.isClosed() {
+		return false
+	}
+	gauge, ok = s.gauges[key]
+	if !ok {
+		gauge = &gaugeSeries{
+			seq:         mb.nextSeq(),
+			name:        name,
+			unit:        unit,
+			tags:        copyTags(tags),
+			aggregation: aggregation,
 		}
+		s.gauges[key] = gauge
 	}
+	gauge.set(value)
+	return true
+}
 
-	for _, name := range mb.consistentlyEmittedMetricNames {
-		if _, ok := matched[name]; !ok {
-			mb.metrics = append(mb.metrics, batch{
-				name:  name,
-				value: 0,
-			})
+func (g *gaugeSeries) set(value float64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	switch {
+	case g.count == 0:
+		g.value = value
+	case g.aggregation == GaugeMin:
+		if value < g.value {
+			g.value = value
+		}
+	case g.aggregation == GaugeMax:
+		if value > g.value {
+			g.value = value
 		}
+	case g.aggregation == GaugeMean:
+		g.value += value
+	default:
+		g.value = value
 	}
+	g.count++
+}
 
-	return localMetrics
+func (g *gaugeSeries) result() float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.aggregation == GaugeMean && g.count > 0 {
+		return g.value / float64(g.count)
+	}
+	return g.value
 }
 
-func (mb *MetricBatcher) AddConsistentlyEmittedMetrics(names ...string) {
-	mb.lock.Lock()
-	defer mb.lock.Unlock()
+// SetCardinalityLimit limits the number of distinct tag sets batched for the
+// named counter or gauge in each flush window. Once limit series have been
+// batched, the tag values of any new series are replaced by
+// metric_sender.OverflowTagValue, and the number of series folded that way is
+// sent at the end of the window as a metric_sender.OverflowCounterName
+// counter. A limit of 0 removes the limit.
+func (mb *MetricBatcher) SetCardinalityLimit(name string, limit int) {
+	mb.cardinality.SetLimit(name, limit)
+}
 
-	mb.consistentlyEmittedMetricNames = append(mb.consistentlyEmittedMetricNames, names...)
+// SetDefaultCardinalityLimit sets the limit for metrics without a limit of
+// their own. See SetCardinalityLimit.
+func (mb *MetricBatcher) SetDefaultCardinalityLimit(limit int) {
+	mb.cardinality.SetDefaultLimit(limit)
+}
+
+func (mb *MetricBatcher) isClosed() bool {
+	return atomic.LoadInt32(&mb.closed) != 0
+}
+
+func (mb *MetricBatcher) nextSeq() uint64 {
+	return atomic.AddUint64(&mb.seq, 1)
+}
+
+func (mb *MetricBatcher) shardFor(key string) *shard {
+	return &mb.shards[shardIndex(key)]
+}
+
+func shardIndex(key string) uint32 {
+	// FNV-1a, inlined to avoid allocating a hash.Hash per update.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h % shardCount
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return copied
 }
 
 type BatchCounterChainer interface {
@@ -198,13 +443,31 @@ func (c batchCounterChainer) SetTag(key, value string) BatchCounterChainer {
 }
 
 func (c batchCounterChainer) Increment() {
-	c.batcher.lock.Lock()
-	defer c.batcher.lock.Unlock()
-	c.batcher.add(batch{name: c.name, value: 1, tags: c.tags})
+	c.batcher.add(c.name, c.tags, 1)
 }
 
 func (c batchCounterChainer) Add(value uint64) {
-	c.batcher.lock.Lock()
-	defer c.batcher.lock.Unlock()
-	c.batcher.add(batch{name: c.name, value: value, tags: c.tags})
+	c.batcher.add(c.name, c.tags, value)
+}
+
+type BatchGaugeChainer interface {
+	SetTag(key, value string) BatchGaugeChainer
+	Set(value float64)
+}
+
+type batchGaugeChainer struct {
+	batcher     *MetricBatcher
+	name        string
+	unit        string
+	aggregation GaugeAggregation
+	tags        map[string]string
+}
+
+func (c batchGaugeChainer) SetTag(key, value string) BatchGaugeChainer {
+	c.tags[key] = value
+	return c
+}
+
+func (c batchGaugeChainer) Set(value float64) {
+	c.batcher.setGauge(c.name, c.unit, c.tags, c.aggregation, value)
 }
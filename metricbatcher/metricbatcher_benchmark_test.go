@@ -0,0 +1,76 @@
+package metricbatcher_test
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
+)
+
+type discardByteEmitter struct{}
+
+func (discardByteEmitter) Emit([]byte) error { return nil }
+func (discardByteEmitter) NonRetaining()     {}
+func (discardByteEmitter) Close()            {}
+
+func newBenchmarkBatcher() *metricbatcher.MetricBatcher {
+	sender := metric_sender.NewMetricSender(emitter.NewEventEmitter(discardByteEmitter{}, "origin"))
+	return metricbatcher.New(sender, time.Hour)
+}
+
+func BenchmarkBatchIncrementCounterParallel(b *testing.B) {
+	batcher := newBenchmarkBatcher()
+	defer batcher.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			batcher.BatchIncrementCounter("requests")
+		}
+	})
+}
+
+func BenchmarkBatchCounterManySeriesParallel(b *testing.B) {
+	for _, series := range []int{100, 10000} {
+		b.Run(strconv.Itoa(series)+" series", func(b *testing.B) {
+			batcher := newBenchmarkBatcher()
+			defer batcher.Close()
+
+			values := make([]string, series)
+			for i := range values {
+				values[i] = "route-" + strconv.Itoa(i)
+			}
+
+			var goroutine int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(atomic.AddInt64(&goroutine, 1)) * 7919
+				for pb.Next() {
+					batcher.BatchCounter("requests").
+						SetTag("route", values[i%series]).
+						Increment()
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkBatchSetGaugeParallel(b *testing.B) {
+	batcher := newBenchmarkBatcher()
+	defer batcher.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			batcher.BatchSetGauge("queueDepth", 12, "count")
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package metricbatcher_test
 
 import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/apoydence/eachers"
@@ -8,7 +12,10 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
 	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/sonde-go/events"
 )
 
 var _ = Describe("MetricBatcher", func() {
@@ -78,6 +85,118 @@ var _ = Describe("MetricBatcher", func() {
 		})
 	})
 
+	Describe("with a metric sender", func() {
+		var (
+			fakeEmitter *fake.FakeEventEmitter
+			batcher     *metricbatcher.MetricBatcher
+		)
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("origin")
+		})
+
+		It("batches counters regardless of the order tags are set in", func() {
+			batcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 5*time.Second)
+
+			batcher.BatchCounter("count").SetTag("a", "1").SetTag("b", "2").Add(2)
+			batcher.BatchCounter("count").SetTag("b", "2").SetTag("a", "1").Add(3)
+			batcher.Close()
+
+			Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(1))
+			Expect(fakeEmitter.GetEnvelopes()[0].GetCounterEvent().GetDelta()).To(BeEquivalentTo(5))
+		})
+
+		Describe("cardinality limits", func() {
+			var deltas = func() map[string]uint64 {
+				deltas := make(map[string]uint64)
+				for _, envelope := range fakeEmitter.GetEnvelopes() {
+					counter := envelope.GetCounterEvent()
+					if counter == nil {
+						continue
+					}
+					switch counter.GetName() {
+					case "requests":
+						deltas[envelope.GetTags()["route"]] += counter.GetDelta()
+					case metric_sender.OverflowCounterName:
+						deltas["folded:"+envelope.GetTags()[metric_sender.OverflowMetricTag]] += counter.GetDelta()
+					}
+				}
+				return deltas
+			}
+
+			BeforeEach(func() {
+				batcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 5*time.Second)
+			})
+
+			It("folds series beyond the limit and counts them", func() {
+				batcher.SetCardinalityLimit("requests", 2)
+
+				for _, route := range []string{"/a", "/b", "/c", "/a", "/d", "/c", "/e"} {
+					batcher.BatchCounter("requests").SetTag("route", route).Increment()
+				}
+				batcher.Close()
+
+				Expect(deltas()).To(Equal(map[string]uint64{
+					"/a":              2,
+					"/b":              1,
+					"__overflow__":    4,
+					"folded:requests": 3,
+				}))
+			})
+
+			It("starts counting series again in each window", func() {
+				batcher.SetDefaultCardinalityLimit(1)
+
+				batcher.BatchCounter("requests").SetTag("route", "/a").Increment()
+				batcher.Reset()
+				batcher.BatchCounter("requests").SetTag("route", "/b").Increment()
+				batcher.Close()
+
+				Expect(deltas()).To(Equal(map[string]uint64{"/b": 1}))
+			})
+
+			It("limits gauges", func() {
+				batcher.SetCardinalityLimit("queueDepth", 1)
+
+				batcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMax).SetTag("queue", "a").Set(1)
+				batcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMax).SetTag("queue", "b").Set(2)
+				batcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMax).SetTag("queue", "c").Set(3)
+				batcher.Close()
+
+				queues := make(map[string]float64)
+				for _, envelope := range fakeEmitter.GetEnvelopes() {
+					if envelope.GetValueMetric().GetName() == "queueDepth" {
+						queues[envelope.GetTags()["queue"]] = envelope.GetValueMetric().GetValue()
+					}
+				}
+				Expect(queues).To(Equal(map[string]float64{"a": 1, "__overflow__": 3}))
+			})
+		})
+
+		It("does not lose updates made while flushing", func() {
+			batcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), time.Millisecond)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						batcher.BatchCounter("count").SetTag("series", strconv.Itoa(j%50)).Increment()
+					}
+				}(i)
+			}
+			wg.Wait()
+			batcher.Close()
+
+			var total uint64
+			for _, envelope := range fakeEmitter.GetEnvelopes() {
+				total += envelope.GetCounterEvent().GetDelta()
+			}
+			Expect(total).To(BeEquivalentTo(8000))
+		})
+	})
+
 	Describe("BatchIncrementCounter", func() {
 		It("accepts metrics while it's flushing metrics", func() {
 			metricBatcher.BatchIncrementCounter("count")
@@ -181,6 +300,140 @@ var _ = Describe("MetricBatcher", func() {
 		})
 	})
 
+	Describe("gauges", func() {
+		var (
+			fakeEmitter  *fake.FakeEventEmitter
+			gaugeBatcher *metricbatcher.MetricBatcher
+		)
+
+		var sentValues = func() []*events.Envelope {
+			var values []*events.Envelope
+			for _, env := range fakeEmitter.GetEnvelopes() {
+				if env.GetEventType() == events.Envelope_ValueMetric {
+					values = append(values, env)
+				}
+			}
+			return values
+		}
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("origin")
+			gaugeBatcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 5*time.Second)
+		})
+
+		Describe("BatchSetGauge", func() {
+			It("sends the last value set on Close", func() {
+				gaugeBatcher.BatchSetGauge("queueDepth", 3, "count")
+				gaugeBatcher.BatchSetGauge("queueDepth", 7, "count")
+				gaugeBatcher.BatchSetGauge("queueDepth", 5, "count")
+				Expect(sentValues()).To(BeEmpty())
+
+				gaugeBatcher.Close()
+
+				values := sentValues()
+				Expect(values).To(HaveLen(1))
+				Expect(values[0].GetValueMetric().GetName()).To(Equal("queueDepth"))
+				Expect(values[0].GetValueMetric().GetValue()).To(Equal(5.0))
+				Expect(values[0].GetValueMetric().GetUnit()).To(Equal("count"))
+			})
+
+			It("sends gauges on the same ticker as counters", func() {
+				gaugeBatcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 50*time.Millisecond)
+				defer gaugeBatcher.Close()
+
+				gaugeBatcher.BatchSetGauge("queueDepth", 3, "count")
+				gaugeBatcher.BatchIncrementCounter("count")
+
+				Eventually(fakeEmitter.GetEnvelopes).Should(HaveLen(2))
+			})
+
+			It("does not resend gauges that were not set in the window", func() {
+				gaugeBatcher.BatchSetGauge("queueDepth", 3, "count")
+				gaugeBatcher.Reset()
+				gaugeBatcher.Close()
+
+				Expect(sentValues()).To(BeEmpty())
+			})
+
+			It("drops gauges when the metric sender cannot send values", func() {
+				counterSender := struct{ metricbatcher.MetricSender }{metric_sender.NewMetricSender(fakeEmitter)}
+				gaugeBatcher = metricbatcher.New(counterSender, 5*time.Second)
+
+				gaugeBatcher.BatchSetGauge("queueDepth", 3, "count")
+				gaugeBatcher.BatchIncrementCounter("count")
+				gaugeBatcher.Close()
+
+				Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(1))
+				Expect(sentValues()).To(BeEmpty())
+			})
+
+			It("panics when setting gauges after closing", func() {
+				gaugeBatcher.Close()
+				Expect(func() {
+					gaugeBatcher.BatchSetGauge("queueDepth", 1, "count")
+				}).To(Panic())
+			})
+		})
+
+		Describe("BatchGauge", func() {
+			DescribeTable("aggregates the values set in a window",
+				func(aggregation metricbatcher.GaugeAggregation, expected float64) {
+					gauge := gaugeBatcher.BatchGauge("latency", "ms", aggregation)
+					for _, v := range []float64{4, 1, 10, 5} {
+						gauge.Set(v)
+					}
+
+					gaugeBatcher.Close()
+
+					values := sentValues()
+					Expect(values).To(HaveLen(1))
+					Expect(values[0].GetValueMetric().GetValue()).To(Equal(expected))
+					Expect(values[0].GetValueMetric().GetUnit()).To(Equal("ms"))
+				},
+				Entry("last", metricbatcher.GaugeLast, 5.0),
+				Entry("min", metricbatcher.GaugeMin, 1.0),
+				Entry("max", metricbatcher.GaugeMax, 10.0),
+				Entry("mean", metricbatcher.GaugeMean, 5.0),
+			)
+
+			It("batches gauges with different tags separately", func() {
+				gaugeBatcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMax).
+					SetTag("queue", "a").
+					Set(1)
+				gaugeBatcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMax).
+					SetTag("queue", "b").
+					Set(2)
+				gaugeBatcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMax).
+					SetTag("queue", "b").
+					Set(3)
+
+				gaugeBatcher.Close()
+
+				byQueue := make(map[string]float64)
+				for _, env := range sentValues() {
+					byQueue[env.GetTags()["queue"]] = env.GetValueMetric().GetValue()
+				}
+				Expect(byQueue).To(Equal(map[string]float64{"a": 1, "b": 3}))
+			})
+
+			It("can set while it flushes without a data race", func() {
+				gaugeBatcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), time.Millisecond)
+				defer gaugeBatcher.Close()
+
+				gauge := gaugeBatcher.BatchGauge("queueDepth", "count", metricbatcher.GaugeMean).SetTag("foo", "bar")
+				after := time.After(100 * time.Millisecond)
+				for {
+					select {
+					case <-after:
+						return
+					default:
+						gauge.Set(1)
+					}
+				}
+			})
+		})
+	})
+
 	Describe("AddConsistentlyEmittedMetrics", func() {
 		It("emits zero values for consistenly emitted metrics", func() {
 			close(mockChainer.AddOutput.Ret0)
@@ -219,6 +472,167 @@ var _ = Describe("MetricBatcher", func() {
 		})
 	})
 
+	Describe("consistently emitted series", func() {
+		var (
+			fakeEmitter *fake.FakeEventEmitter
+			batcher     *metricbatcher.MetricBatcher
+		)
+
+		var sent = func(name string) []*events.Envelope {
+			var envelopes []*events.Envelope
+			for _, envelope := range fakeEmitter.GetEnvelopes() {
+				if envelope.GetCounterEvent().GetName() == name || envelope.GetValueMetric().GetName() == name {
+					envelopes = append(envelopes, envelope)
+				}
+			}
+			return envelopes
+		}
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("origin")
+			batcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 5*time.Second)
+		})
+
+		It("sends a zero delta for counters that were not updated", func() {
+			batcher.AddConsistentlyEmittedCounter("requests", map[string]string{"route": "/"})
+			batcher.Close()
+
+			Expect(sent("requests")).To(HaveLen(1))
+			Expect(sent("requests")[0].GetCounterEvent().GetDelta()).To(BeZero())
+			Expect(sent("requests")[0].GetTags()).To(HaveKeyWithValue("route", "/"))
+		})
+
+		It("sends counters that were updated once", func() {
+			batcher.AddConsistentlyEmittedCounter("requests", map[string]string{"route": "/"})
+			batcher.BatchCounter("requests").SetTag("route", "/").Add(3)
+			batcher.BatchCounter("requests").SetTag("route", "/other").Add(1)
+			batcher.Close()
+
+			Expect(sent("requests")).To(HaveLen(2))
+			Expect(sent("requests")[0].GetCounterEvent().GetDelta()).To(BeEquivalentTo(3))
+			Expect(sent("requests")[0].GetTags()).To(HaveKeyWithValue("route", "/"))
+		})
+
+		It("sends zero for gauges that were never set", func() {
+			batcher.AddConsistentlyEmittedGauge("connections", "count", map[string]string{"az": "z1"})
+			batcher.Close()
+
+			Expect(sent("connections")).To(HaveLen(1))
+			Expect(sent("connections")[0].GetValueMetric().GetValue()).To(BeZero())
+			Expect(sent("connections")[0].GetValueMetric().GetUnit()).To(Equal("count"))
+			Expect(sent("connections")[0].GetTags()).To(HaveKeyWithValue("az", "z1"))
+		})
+
+		It("sends the last value of gauges that were not set", func() {
+			batcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 20*time.Millisecond)
+			batcher.AddConsistentlyEmittedGauge("connections", "count", map[string]string{"az": "z1"})
+			batcher.BatchGauge("connections", "count", metricbatcher.GaugeMax).SetTag("az", "z1").Set(4)
+
+			Eventually(func() []*events.Envelope { return sent("connections") }).Should(HaveLen(3))
+			batcher.Close()
+			for _, envelope := range sent("connections") {
+				Expect(envelope.GetValueMetric().GetValue()).To(BeEquivalentTo(4))
+			}
+		})
+
+		It("stops sending series that are removed", func() {
+			batcher.AddConsistentlyEmittedCounter("requests", map[string]string{"route": "/"})
+			batcher.AddConsistentlyEmittedGauge("connections", "count", nil)
+			batcher.AddConsistentlyEmittedMetrics("count1", "count2")
+
+			batcher.RemoveConsistentlyEmittedCounter("requests", map[string]string{"route": "/"})
+			batcher.RemoveConsistentlyEmittedGauge("connections", nil)
+			batcher.RemoveConsistentlyEmittedMetrics("count1")
+			batcher.Reset()
+			batcher.Close()
+
+			Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(1))
+			Expect(sent("count2")).To(HaveLen(1))
+		})
+	})
+
+	Describe("Flush", func() {
+		var fakeEmitter *fake.FakeEventEmitter
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("origin")
+			metricBatcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour)
+		})
+
+		AfterEach(func() {
+			metricBatcher.Close()
+		})
+
+		It("sends batched metrics immediately", func() {
+			metricBatcher.BatchAddCounter("count", 2)
+			metricBatcher.BatchSetGauge("gauge", 3, "count")
+
+			Expect(metricBatcher.Flush()).To(Succeed())
+			Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(2))
+
+			Expect(metricBatcher.Flush()).To(Succeed())
+			Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(2))
+		})
+
+		It("returns send errors", func() {
+			fakeEmitter.ReturnError = errors.New("send failed")
+			metricBatcher.BatchAddCounter("count", 2)
+			metricBatcher.BatchSetGauge("gauge", 3, "count")
+
+			err := metricBatcher.Flush()
+			Expect(err).To(MatchError(ContainSubstring("failed to send 1 of 2 metrics")))
+			Expect(errors.Unwrap(err)).To(MatchError("send failed"))
+		})
+
+		It("applies the tag limit to batched counters merged with the default tags", func() {
+			metricBatcher.Close()
+			sender := metric_sender.NewMetricSender(fakeEmitter)
+			Expect(sender.SetDefaultTags(map[string]string{"az": "z1", "env": "prod"})).To(Succeed())
+			metricBatcher = metricbatcher.New(sender, time.Hour)
+
+			counter := metricBatcher.BatchCounter("count")
+			for i := 0; i < 10; i++ {
+				counter = counter.SetTag("tag"+strconv.Itoa(i), "value")
+			}
+			counter.Increment()
+
+			Expect(metricBatcher.Flush()).To(MatchError(ContainSubstring("Too many tags")))
+			Expect(fakeEmitter.GetEnvelopes()).To(BeEmpty())
+		})
+	})
+
+	Describe("scheduling", func() {
+		var fakeEmitter *fake.FakeEventEmitter
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("origin")
+		})
+
+		It("panics for a non-positive batch duration", func() {
+			Expect(func() {
+				metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 0)
+			}).To(Panic())
+		})
+
+		It("flushes aligned to the batch duration", func() {
+			batcher := metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 20*time.Millisecond, metricbatcher.WithAlignment())
+			defer batcher.Close()
+
+			batcher.BatchIncrementCounter("count")
+			Eventually(fakeEmitter.GetEnvelopes).Should(HaveLen(1))
+		})
+
+		It("flushes with jitter", func() {
+			batcher := metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), 20*time.Millisecond, metricbatcher.WithJitter(30*time.Millisecond))
+			defer batcher.Close()
+
+			batcher.BatchIncrementCounter("count")
+			Eventually(fakeEmitter.GetEnvelopes).Should(HaveLen(1))
+			batcher.BatchIncrementCounter("count")
+			Eventually(fakeEmitter.GetEnvelopes).Should(HaveLen(2))
+		})
+	})
+
 	Describe("Reset", func() {
 		It("cancels any scheduled counter emission", func() {
 			metricBatcher.BatchAddCounter("count1", 2)
@@ -252,5 +666,36 @@ var _ = Describe("MetricBatcher", func() {
 				metricBatcher.BatchAddCounter("count3", 3)
 			}).To(Panic())
 		})
+
+		It("flushes every update made while closing that does not panic", func() {
+			fakeEmitter := fake.NewFakeEventEmitter("origin")
+			metricBatcher = metricbatcher.New(metric_sender.NewMetricSender(fakeEmitter), time.Hour)
+
+			var (
+				wg    sync.WaitGroup
+				added uint64
+			)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					defer func() { recover() }()
+					for {
+						metricBatcher.BatchAddCounter("racing", 1)
+						atomic.AddUint64(&added, 1)
+					}
+				}()
+			}
+			Eventually(func() uint64 { return atomic.LoadUint64(&added) }).Should(BeNumerically(">", 1000))
+			metricBatcher.Close()
+			wg.Wait()
+
+			var flushed uint64
+			for _, envelope := range fakeEmitter.GetEnvelopes() {
+				flushed += envelope.GetCounterEvent().GetDelta()
+			}
+			Expect(flushed).To(Equal(atomic.LoadUint64(&added)))
+		})
 	})
 })
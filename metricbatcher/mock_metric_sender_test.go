@@ -10,6 +10,15 @@ type mockMetricSender struct {
 	CounterOutput struct {
 		Ret0 chan metric_sender.CounterChainer
 	}
+	ValueCalled chan bool
+	ValueInput  struct {
+		Name  chan string
+		Value chan float64
+		Unit  chan string
+	}
+	ValueOutput struct {
+		Ret0 chan metric_sender.ValueChainer
+	}
 }
 
 func newMockMetricSender() *mockMetricSender {
@@ -17,6 +26,11 @@ func newMockMetricSender() *mockMetricSender {
 	m.CounterCalled = make(chan bool, 100)
 	m.CounterInput.Name = make(chan string, 100)
 	m.CounterOutput.Ret0 = make(chan metric_sender.CounterChainer, 100)
+	m.ValueCalled = make(chan bool, 100)
+	m.ValueInput.Name = make(chan string, 100)
+	m.ValueInput.Value = make(chan float64, 100)
+	m.ValueInput.Unit = make(chan string, 100)
+	m.ValueOutput.Ret0 = make(chan metric_sender.ValueChainer, 100)
 	return m
 }
 func (m *mockMetricSender) Counter(name string) metric_sender.CounterChainer {
@@ -24,3 +38,10 @@ func (m *mockMetricSender) Counter(name string) metric_sender.CounterChainer {
 	m.CounterInput.Name <- name
 	return <-m.CounterOutput.Ret0
 }
+func (m *mockMetricSender) Value(name string, value float64, unit string) metric_sender.ValueChainer {
+	m.ValueCalled <- true
+	m.ValueInput.Name <- name
+	m.ValueInput.Value <- value
+	m.ValueInput.Unit <- unit
+	return <-m.ValueOutput.Ret0
+}
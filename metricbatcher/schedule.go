@@ -0,0 +1,95 @@
+package metricbatcher
+
+import (
+	"math/rand"
+	"time"
+)
+
+// An Option configures a MetricBatcher created by New.
+type Option func(*schedule)
+
+// WithAlignment makes flushes happen at wall-clock multiples of the batch
+// duration, so that, for example, a batcher flushing every minute flushes on
+// the minute. The first window is shortened to reach the first boundary.
+func WithAlignment() Option {
+	return func(s *schedule) {
+		s.aligned = true
+	}
+}
+
+// WithJitter delays each flush by a random duration of up to max, so that
+// batchers started together do not flush at the same instant. The delay does
+// not accumulate: flushes stay on average one batch duration apart.
+func WithJitter(max time.Duration) Option {
+	return func(s *schedule) {
+		if max > 0 {
+			s.jitter = max
+		}
+	}
+}
+
+// schedule computes when flushes happen.
+type schedule struct {
+	period  time.Duration
+	aligned bool
+	jitter  time.Duration
+	rand    *rand.Rand
+
+	// next is the time the next flush is due, before jitter.
+	next time.Time
+}
+
+func newSchedule(period time.Duration, now time.Time, opts []Option) *schedule {
+	s := &schedule{period: period}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.jitter > 0 {
+		s.rand = rand.New(rand.NewSource(now.UnixNano()))
+	}
+
+	if s.aligned {
+		s.next = now.Truncate(period).Add(period)
+	} else {
+		s.next = now.Add(period)
+	}
+	return s
+}
+
+// wait returns how long to wait from now until the next flush.
+func (s *schedule) wait(now time.Time) time.Duration {
+	d := s.next.Sub(now)
+	if s.rand != nil {
+		d += time.Duration(s.rand.Int63n(int64(s.jitter)))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// advance moves the schedule to the flush after the one due, skipping any
+// that were missed.
+func (s *schedule) advance(now time.Time) {
+	s.next = s.next.Add(s.period)
+	if s.next.Before(now) {
+		missed := now.Sub(s.next)/s.period + 1
+		s.next = s.next.Add(missed * s.period)
+	}
+}
+
+func (mb *MetricBatcher) run(s *schedule) {
+	timer := time.NewTimer(s.wait(time.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			mb.flush(mb.swap())
+			s.advance(time.Now())
+			timer.Reset(s.wait(time.Now()))
+		case <-mb.closedChan:
+			return
+		}
+	}
+}
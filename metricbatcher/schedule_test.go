@@ -0,0 +1,74 @@
+package metricbatcher
+
+import (
+	"math/rand"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("schedule", func() {
+	const period = 200 * time.Millisecond
+	start := time.Date(2024, 1, 1, 12, 0, 0, 150*int(time.Millisecond), time.UTC)
+
+	DescribeTable("the first flush",
+		func(opts []Option, next time.Time) {
+			Expect(newSchedule(period, start, opts).next).To(Equal(next))
+		},
+		Entry("is one period away", nil, start.Add(period)),
+		Entry("is at the next multiple of the period when aligned",
+			[]Option{WithAlignment()}, time.Date(2024, 1, 1, 12, 0, 0, 200*int(time.Millisecond), time.UTC)),
+		Entry("is not delayed by jitter", []Option{WithJitter(time.Second)}, start.Add(period)),
+	)
+
+	DescribeTable("wait",
+		func(untilNext time.Duration, expected time.Duration) {
+			s := &schedule{period: period, next: start.Add(untilNext)}
+			Expect(s.wait(start)).To(Equal(expected))
+		},
+		Entry("waits until the next flush", 50*time.Millisecond, 50*time.Millisecond),
+		Entry("does not wait for an overdue flush", -50*time.Millisecond, time.Duration(0)),
+	)
+
+	It("delays flushes by at most the jitter", func() {
+		const jitter = 30 * time.Millisecond
+		s := &schedule{period: period, jitter: jitter, rand: rand.New(rand.NewSource(1)), next: start.Add(period)}
+
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			delay := s.wait(start) - period
+			Expect(delay).To(BeNumerically(">=", 0))
+			Expect(delay).To(BeNumerically("<", jitter))
+			if delay > longest {
+				longest = delay
+			}
+		}
+		Expect(longest).To(BeNumerically(">", jitter/2))
+	})
+
+	DescribeTable("advance",
+		func(elapsed time.Duration, next time.Duration) {
+			s := &schedule{period: period, next: start}
+			s.advance(start.Add(elapsed))
+			Expect(s.next).To(Equal(start.Add(next)))
+		},
+		Entry("moves to the following flush", 10*time.Millisecond, period),
+		Entry("moves to the following flush when flushing on time", time.Duration(0), period),
+		Entry("skips missed flushes", 5*period/2, 3*period),
+		Entry("skips missed flushes up to a boundary", 2*period, 3*period),
+	)
+
+	It("keeps jittered flushes one period apart on average", func() {
+		const flushes = 1000
+		s := &schedule{period: period, jitter: 150 * time.Millisecond, rand: rand.New(rand.NewSource(1)), next: start.Add(period)}
+
+		now := start
+		for i := 0; i < flushes; i++ {
+			now = now.Add(s.wait(now))
+			s.advance(now)
+		}
+
+		Expect(now.Sub(start) / flushes).To(BeNumerically("~", period, period/20))
+	})
+})
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultAggregationInterval is how often aggregated metrics, such as
+// histograms and timers, are flushed.
+const DefaultAggregationInterval = 5 * time.Second
+
+// aggregate is implemented by metrics that are accumulated in memory and
+// sent periodically instead of on every observation.
+type aggregate interface {
+	flush(MetricSender)
+}
+
+// aggregateKey identifies a registered aggregate. Aggregates of different
+// kinds are kept apart, so that a name used for both a meter and a timer
+// returns a meter and a timer.
+type aggregateKey struct {
+	kind string
+	name string
+}
+
+var (
+	aggregatesLock sync.Mutex
+	aggregates     = make(map[aggregateKey]aggregate)
+
+	aggregationLock     sync.Mutex
+	aggregationInterval = DefaultAggregationInterval
+	aggregationStop     chan struct{}
+	aggregationDone     chan struct{}
+)
+
+// SetAggregationInterval changes how often aggregated metrics are flushed.
+// It takes effect the next time the package is initialized.
+func SetAggregationInterval(interval time.Duration) {
+	aggregationLock.Lock()
+	defer aggregationLock.Unlock()
+	aggregationInterval = interval
+}
+
+// FlushAggregates immediately sends every aggregated metric that has been
+// observed since the last flush.
+func FlushAggregates() {
+	sender := metricSender
+	if sender == nil {
+		return
+	}
+
+	aggregatesLock.Lock()
+	pending := make([]aggregate, 0, len(aggregates))
+	for _, a := range aggregates {
+		pending = append(pending, a)
+	}
+	aggregatesLock.Unlock()
+
+	for _, a := range pending {
+		a.flush(sender)
+	}
+}
+
+// registerAggregate returns the aggregate of the given kind already
+// registered under name, or registers and returns the one built by create.
+func registerAggregate(kind, name string, create func() aggregate) aggregate {
+	aggregatesLock.Lock()
+	defer aggregatesLock.Unlock()
+
+	key := aggregateKey{kind: kind, name: name}
+	if a, ok := aggregates[key]; ok {
+		return a
+	}
+	a := create()
+	aggregates[key] = a
+	return a
+}
+
+func startAggregation() {
+	aggregationLock.Lock()
+	defer aggregationLock.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	aggregationStop = stop
+	aggregationDone = done
+
+	go func(interval time.Duration) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				FlushAggregates()
+			case <-stop:
+				return
+			}
+		}
+	}(aggregationInterval)
+}
+
+func stopAggregation() {
+	aggregationLock.Lock()
+	defer aggregationLock.Unlock()
+
+	if aggregationStop == nil {
+		return
+	}
+	close(aggregationStop)
+	<-aggregationDone
+	aggregationStop = nil
+	aggregationDone = nil
+}
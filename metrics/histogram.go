@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+)
+
+var (
+	// DefaultPercentiles are the percentiles histograms and timers report
+	// unless configured otherwise.
+	DefaultPercentiles = []float64{0.5, 0.9, 0.99}
+
+	// DefaultTimerBuckets are the bucket upper bounds, in milliseconds, used
+	// by timers.
+	DefaultTimerBuckets = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+// HistogramMetric accumulates observations into buckets. Every aggregation
+// interval, for each histogram that was observed since the previous flush,
+// the following are sent:
+//
+//	name.bucket  CounterEvents with the cumulative count of observations
+//	             less than or equal to the bound given by the "le" tag
+//	name.count   a CounterEvent with the number of observations
+//	name.sum     a ValueMetric with the sum of the observations
+//	name         ValueMetrics with the percentile given by the "quantile"
+//	             tag, estimated from the buckets
+type HistogramMetric struct {
+	name   string
+	bounds []float64
+
+	lock        sync.Mutex
+	unit        string
+	percentiles []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+	min         float64
+	max         float64
+}
+
+// Histogram returns the histogram with the given name, creating it with the
+// given bucket upper bounds if it does not exist yet. A final bucket without
+// an upper bound is always added.
+func Histogram(name string, buckets []float64) *HistogramMetric {
+	return registerAggregate("histogram", name, func() aggregate {
+		return newHistogram(name, buckets, "count")
+	}).(*HistogramMetric)
+}
+
+func newHistogram(name string, buckets []float64, unit string) *HistogramMetric {
+	bounds := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		if !math.IsNaN(b) && !math.IsInf(b, 1) {
+			bounds = append(bounds, b)
+		}
+	}
+	sort.Float64s(bounds)
+	bounds = dedupSorted(bounds)
+
+	return &HistogramMetric{
+		name:        name,
+		bounds:      bounds,
+		unit:        unit,
+		percentiles: DefaultPercentiles,
+		counts:      make([]uint64, len(bounds)+1),
+	}
+}
+
+// WithUnit sets the unit the sum and percentiles are sent with. It returns
+// the histogram so that it can be used when the histogram is created.
+func (h *HistogramMetric) WithUnit(unit string) *HistogramMetric {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.unit = unit
+	return h
+}
+
+// WithPercentiles sets the percentiles, between 0 and 1, that are reported.
+// It returns the histogram so that it can be used when the histogram is
+// created.
+func (h *HistogramMetric) WithPercentiles(percentiles ...float64) *HistogramMetric {
+	valid := make([]float64, 0, len(percentiles))
+	for _, p := range percentiles {
+		if p > 0 && p <= 1 {
+			valid = append(valid, p)
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.percentiles = valid
+	return h
+}
+
+// Observe records a single observation. NaN values are ignored.
+func (h *HistogramMetric) Observe(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	i := sort.SearchFloat64s(h.bounds, value)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	if h.count == 0 || value > h.max {
+		h.max = value
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += value
+}
+
+func (h *HistogramMetric) flush(sender MetricSender) {
+	h.lock.Lock()
+	if h.count == 0 {
+		h.lock.Unlock()
+		return
+	}
+	snapshot := histogramSnapshot{
+		bounds: h.bounds,
+		counts: h.counts,
+		count:  h.count,
+		sum:    h.sum,
+		min:    h.min,
+		max:    h.max,
+	}
+	unit, percentiles := h.unit, h.percentiles
+	h.counts = make([]uint64, len(h.bounds)+1)
+	h.count, h.sum, h.min, h.max = 0, 0, 0, 0
+	h.lock.Unlock()
+
+	if err := check(h.name, metric_registry.Histogram, unit); err != nil {
+		log.Printf("metrics: dropped histogram %s: %v", h.name, err)
+		return
+	}
+
+	var errs []error
+	var cumulative uint64
+	for i, c := range snapshot.counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(snapshot.bounds) {
+			le = formatFloat(snapshot.bounds[i])
+		}
+		errs = append(errs, sender.Counter(h.name+".bucket").SetTag("le", le).Add(cumulative))
+	}
+	errs = append(errs, sender.Counter(h.name+".count").Add(snapshot.count))
+	errs = append(errs, sender.Value(h.name+".sum", snapshot.sum, unit).Send())
+	for _, p := range percentiles {
+		value := snapshot.percentile(p)
+		errs = append(errs, sender.Value(h.name, value, unit).SetTag("quantile", formatFloat(p)).Send())
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("metrics: failed to flush histogram %s: %v", h.name, err)
+			return
+		}
+	}
+}
+
+type histogramSnapshot struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+// percentile estimates the p-th percentile by linear interpolation within the
+// bucket it falls into. Bucket bounds are narrowed to the observed minimum
+// and maximum, which also bounds the last bucket.
+func (s histogramSnapshot) percentile(p float64) float64 {
+	rank := p * float64(s.count)
+
+	var cumulative uint64
+	for i, c := range s.counts {
+		if c == 0 {
+			continue
+		}
+		if float64(cumulative+c) >= rank {
+			lower := s.min
+			if i > 0 && s.bounds[i-1] > lower {
+				lower = s.bounds[i-1]
+			}
+			upper := s.max
+			if i < len(s.bounds) && s.bounds[i] < upper {
+				upper = s.bounds[i]
+			}
+			return lower + (upper-lower)*(rank-float64(cumulative))/float64(c)
+		}
+		cumulative += c
+	}
+	return s.max
+}
+
+// TimerMetric is a histogram of durations, sent in milliseconds.
+type TimerMetric struct {
+	histogram *HistogramMetric
+}
+
+// Timer returns the timer with the given name, creating it with
+// DefaultTimerBuckets if it does not exist yet.
+func Timer(name string) *TimerMetric {
+	histogram := registerAggregate("timer", name, func() aggregate {
+		return newHistogram(name, DefaultTimerBuckets, "ms")
+	}).(*HistogramMetric)
+	return &TimerMetric{histogram: histogram}
+}
+
+// WithPercentiles sets the percentiles, between 0 and 1, that are reported.
+func (t *TimerMetric) WithPercentiles(percentiles ...float64) *TimerMetric {
+	t.histogram.WithPercentiles(percentiles...)
+	return t
+}
+
+// Observe records a single duration.
+func (t *TimerMetric) Observe(d time.Duration) {
+	t.histogram.Observe(float64(d) / float64(time.Millisecond))
+}
+
+// Time calls f and records how long it took.
+func (t *TimerMetric) Time(f func()) {
+	start := time.Now()
+	defer func() {
+		t.Observe(time.Since(start))
+	}()
+	f()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func dedupSorted(values []float64) []float64 {
+	if len(values) == 0 {
+		return values
+	}
+	out := values[:1]
+	for _, v := range values[1:] {
+		if v != out[len(out)-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package metrics_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Histogram", func() {
+	var fakeEmitter *fake.FakeEventEmitter
+
+	var valueMetrics = func(name string) map[string]*events.ValueMetric {
+		values := make(map[string]*events.ValueMetric)
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			if env.GetValueMetric().GetName() == name {
+				values[env.GetTags()["quantile"]] = env.GetValueMetric()
+			}
+		}
+		return values
+	}
+
+	var counterEvents = func(name string) map[string]uint64 {
+		counters := make(map[string]uint64)
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			if env.GetCounterEvent().GetName() == name {
+				counters[env.GetTags()["le"]] = env.GetCounterEvent().GetDelta()
+			}
+		}
+		return counters
+	}
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		metrics.Initialize(metric_sender.NewMetricSender(fakeEmitter), newMockMetricBatcher())
+	})
+
+	It("returns the same histogram for the same name", func() {
+		Expect(metrics.Histogram("same", nil)).To(BeIdenticalTo(metrics.Histogram("same", nil)))
+	})
+
+	It("sends cumulative bucket counts, the count and the sum", func() {
+		histogram := metrics.Histogram("sizes", []float64{30, 10, 20}).WithUnit("bytes")
+		for _, v := range []float64{5, 15, 15, 25, 100} {
+			histogram.Observe(v)
+		}
+
+		metrics.FlushAggregates()
+
+		Expect(counterEvents("sizes.bucket")).To(Equal(map[string]uint64{
+			"10":   1,
+			"20":   3,
+			"30":   4,
+			"+Inf": 5,
+		}))
+		Expect(counterEvents("sizes.count")).To(Equal(map[string]uint64{"": 5}))
+
+		sum := valueMetrics("sizes.sum")[""]
+		Expect(sum.GetValue()).To(Equal(160.0))
+		Expect(sum.GetUnit()).To(Equal("bytes"))
+	})
+
+	It("sends percentiles interpolated within buckets", func() {
+		histogram := metrics.Histogram("latency", []float64{10, 20, 30}).WithPercentiles(0.5, 0.99)
+		for _, v := range []float64{5, 15, 15, 25} {
+			histogram.Observe(v)
+		}
+
+		metrics.FlushAggregates()
+
+		percentiles := valueMetrics("latency")
+		Expect(percentiles).To(HaveLen(2))
+		Expect(percentiles["0.5"].GetValue()).To(Equal(15.0))
+		Expect(percentiles["0.99"].GetValue()).To(BeNumerically("~", 24.8, 0.0001))
+	})
+
+	It("resets after each flush and sends nothing without observations", func() {
+		histogram := metrics.Histogram("resets", []float64{1})
+		histogram.Observe(1)
+		metrics.FlushAggregates()
+		fakeEmitter.Reset()
+
+		metrics.FlushAggregates()
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			Expect(env.GetCounterEvent().GetName() + env.GetValueMetric().GetName()).ToNot(HavePrefix("resets"))
+		}
+
+		histogram.Observe(2)
+		metrics.FlushAggregates()
+		Expect(counterEvents("resets.count")).To(Equal(map[string]uint64{"": 1}))
+	})
+
+	It("flushes on Close", func() {
+		metrics.Histogram("closing", nil).Observe(1)
+
+		metrics.Close()
+
+		Expect(counterEvents("closing.count")).To(Equal(map[string]uint64{"": 1}))
+	})
+
+	It("flushes every aggregation interval", func() {
+		metrics.SetAggregationInterval(10 * time.Millisecond)
+		defer metrics.SetAggregationInterval(metrics.DefaultAggregationInterval)
+		metrics.Initialize(metric_sender.NewMetricSender(fakeEmitter), newMockMetricBatcher())
+		defer metrics.Close()
+
+		metrics.Histogram("ticking", nil).Observe(1)
+
+		Eventually(func() map[string]uint64 {
+			return counterEvents("ticking.count")
+		}).Should(Equal(map[string]uint64{"": 1}))
+	})
+})
+
+var _ = Describe("Timer", func() {
+	var fakeEmitter *fake.FakeEventEmitter
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		metrics.Initialize(metric_sender.NewMetricSender(fakeEmitter), newMockMetricBatcher())
+	})
+
+	It("records durations in milliseconds", func() {
+		timer := metrics.Timer("requestTime").WithPercentiles(1)
+		timer.Observe(250 * time.Millisecond)
+
+		metrics.FlushAggregates()
+
+		var sum, max *events.ValueMetric
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			switch env.GetValueMetric().GetName() {
+			case "requestTime.sum":
+				sum = env.GetValueMetric()
+			case "requestTime":
+				max = env.GetValueMetric()
+			}
+		}
+		Expect(sum.GetValue()).To(Equal(250.0))
+		Expect(sum.GetUnit()).To(Equal("ms"))
+		Expect(max.GetValue()).To(Equal(250.0))
+	})
+
+	It("times a function", func() {
+		metrics.Timer("sleepTime").Time(func() {
+			time.Sleep(10 * time.Millisecond)
+		})
+
+		metrics.FlushAggregates()
+
+		var sum float64
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			if env.GetValueMetric().GetName() == "sleepTime.sum" {
+				sum = env.GetValueMetric().GetValue()
+			}
+		}
+		Expect(sum).To(BeNumerically(">=", 10))
+	})
+})
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+)
+
+// meterWindows are the periods of the moving averages kept by meters, with
+// the suffixes of their names.
+var meterWindows = []struct {
+	suffix string
+	window time.Duration
+}{
+	{".m1_rate", time.Minute},
+	{".m5_rate", 5 * time.Minute},
+	{".m15_rate", 15 * time.Minute},
+}
+
+// MeterMetric counts events and computes their rate in process. Every
+// aggregation interval, once a meter has been marked for the first time, the
+// following are sent:
+//
+//	name           a CounterEvent with the number of events, omitted when
+//	               there were none
+//	name.rate      a ValueMetric with the rate since the previous flush
+//	name.m1_rate   ValueMetrics with the exponentially weighted moving
+//	name.m5_rate   averages of the rate over 1, 5 and 15 minutes
+//	name.m15_rate
+//
+// Rates are sent in per_second. While a meter is not marked, its rate is
+// sent as zero and its averages keep decaying towards zero.
+type MeterMetric struct {
+	name string
+
+	lock      sync.Mutex
+	count     uint64
+	lastFlush time.Time
+	averages  []float64
+	primed    bool
+}
+
+// Meter returns the meter with the given name, creating it if it does not
+// exist yet.
+func Meter(name string) *MeterMetric {
+	return registerAggregate("meter", name, func() aggregate {
+		return &MeterMetric{
+			name:      name,
+			lastFlush: time.Now(),
+			averages:  make([]float64, len(meterWindows)),
+		}
+	}).(*MeterMetric)
+}
+
+// Mark records a single event.
+func (m *MeterMetric) Mark() {
+	m.MarkN(1)
+}
+
+// MarkN records n events.
+func (m *MeterMetric) MarkN(n uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.count += n
+}
+
+func (m *MeterMetric) flush(sender MetricSender) {
+	now := time.Now()
+
+	m.lock.Lock()
+	elapsed := now.Sub(m.lastFlush).Seconds()
+	if elapsed <= 0 || (m.count == 0 && !m.primed) {
+		m.lock.Unlock()
+		return
+	}
+	count := m.count
+	rate := float64(count) / elapsed
+	for i, w := range meterWindows {
+		if m.primed {
+			alpha := 1 - math.Exp(-elapsed/w.window.Seconds())
+			m.averages[i] += alpha * (rate - m.averages[i])
+		} else {
+			m.averages[i] = rate
+		}
+	}
+	averages := append([]float64(nil), m.averages...)
+	m.primed = true
+	m.count = 0
+	m.lastFlush = now
+	m.lock.Unlock()
+
+	if err := check(m.name, metric_registry.Meter, "per_second"); err != nil {
+		log.Printf("metrics: dropped meter %s: %v", m.name, err)
+		return
+	}
+
+	var errs []error
+	if count > 0 {
+		errs = append(errs, sender.Counter(m.name).Add(count))
+	}
+	errs = append(errs, sender.Value(m.name+".rate", rate, "per_second").Send())
+	for i, w := range meterWindows {
+		errs = append(errs, sender.Value(m.name+w.suffix, averages[i], "per_second").Send())
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("metrics: failed to flush meter %s: %v", m.name, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package metrics_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Meter", func() {
+	var fakeEmitter *fake.FakeEventEmitter
+
+	var values = func() map[string]float64 {
+		values := make(map[string]float64)
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			if metric := env.GetValueMetric(); metric != nil {
+				Expect(metric.GetUnit()).To(Equal("per_second"))
+				values[metric.GetName()] = metric.GetValue()
+			}
+		}
+		return values
+	}
+
+	var counts = func(name string) []uint64 {
+		var counts []uint64
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			if env.GetCounterEvent().GetName() == name {
+				counts = append(counts, env.GetCounterEvent().GetDelta())
+			}
+		}
+		return counts
+	}
+
+	var envelopesFor = func(name string) []*events.Envelope {
+		var envelopes []*events.Envelope
+		for _, env := range fakeEmitter.GetEnvelopes() {
+			metric := env.GetCounterEvent().GetName() + env.GetValueMetric().GetName()
+			if strings.HasPrefix(metric, name) {
+				envelopes = append(envelopes, env)
+			}
+		}
+		return envelopes
+	}
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		metrics.Initialize(metric_sender.NewMetricSender(fakeEmitter), newMockMetricBatcher())
+	})
+
+	It("returns the same meter for the same name", func() {
+		Expect(metrics.Meter("sameMeter")).To(BeIdenticalTo(metrics.Meter("sameMeter")))
+	})
+
+	It("does not collide with histograms and timers of the same name", func() {
+		var meter *metrics.MeterMetric
+		Expect(func() {
+			metrics.Histogram("shared", nil).Observe(1)
+			meter = metrics.Meter("shared")
+			metrics.Timer("shared").Observe(time.Millisecond)
+			metrics.FlushAggregates()
+		}).ToNot(Panic())
+		Expect(metrics.Meter("shared")).To(BeIdenticalTo(meter))
+	})
+
+	It("sends the count and the rate since the previous flush", func() {
+		start := time.Now()
+		meter := metrics.Meter("requests")
+		meter.Mark()
+		meter.MarkN(99)
+		time.Sleep(10 * time.Millisecond)
+
+		metrics.FlushAggregates()
+		elapsed := time.Since(start).Seconds()
+
+		Expect(counts("requests")).To(Equal([]uint64{100}))
+		Expect(values()["requests.rate"]).To(BeNumerically(">=", 100/elapsed))
+		Expect(values()["requests.rate"]).To(BeNumerically("<=", 100/0.01))
+	})
+
+	It("starts the moving averages at the first rate and decays them while idle", func() {
+		meter := metrics.Meter("decaying")
+		meter.MarkN(50)
+		time.Sleep(10 * time.Millisecond)
+		metrics.FlushAggregates()
+
+		first := values()
+		Expect(first["decaying.m1_rate"]).To(Equal(first["decaying.rate"]))
+		Expect(first["decaying.m5_rate"]).To(Equal(first["decaying.rate"]))
+		Expect(first["decaying.m15_rate"]).To(Equal(first["decaying.rate"]))
+
+		fakeEmitter.Reset()
+		time.Sleep(10 * time.Millisecond)
+		metrics.FlushAggregates()
+
+		idle := values()
+		Expect(counts("decaying")).To(BeEmpty())
+		Expect(idle).To(HaveKeyWithValue("decaying.rate", 0.0))
+		Expect(idle["decaying.m1_rate"]).To(BeNumerically("<", first["decaying.m1_rate"]))
+		Expect(idle["decaying.m1_rate"]).To(BeNumerically(">", 0))
+
+		fakeEmitter.Reset()
+		meter.Mark()
+		time.Sleep(10 * time.Millisecond)
+		metrics.FlushAggregates()
+
+		second := values()
+		Expect(counts("decaying")).To(Equal([]uint64{1}))
+		Expect(second["decaying.rate"]).To(BeNumerically("<", first["decaying.rate"]))
+		Expect(second["decaying.m1_rate"]).To(BeNumerically("<", idle["decaying.m1_rate"]))
+		Expect(second["decaying.m5_rate"]).To(BeNumerically(">", second["decaying.m1_rate"]))
+		Expect(second["decaying.m15_rate"]).To(BeNumerically(">", second["decaying.m5_rate"]))
+	})
+
+	It("sends nothing until it is marked", func() {
+		metrics.Meter("idle")
+
+		metrics.FlushAggregates()
+
+		Expect(envelopesFor("idle")).To(BeEmpty())
+	})
+
+	It("is checked against the registry as a meter", func() {
+		registry := metric_registry.New(metric_registry.Strict)
+		registry.MustRegister(metric_registry.Descriptor{Name: "checkedMeter", Kind: metric_registry.Counter})
+		metrics.SetRegistry(registry)
+		defer metrics.SetRegistry(nil)
+
+		metrics.Meter("checkedMeter").Mark()
+		metrics.FlushAggregates()
+
+		Expect(envelopesFor("checkedMeter")).To(BeEmpty())
+	})
+})
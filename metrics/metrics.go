@@ -17,10 +17,28 @@
 // to increment a counter. (Note that the value of the counter is maintained by
 // the receiver of the counter events, not the application that includes this
 // package.)
+//
+// Latencies and other distributions can be aggregated in process with
+//
+//		metrics.Timer(name).Time(func() { ... })
+//		metrics.Histogram(name, buckets).Observe(value)
+//
+// which send bucket counts, a count, a sum and percentiles once every
+// aggregation interval rather than one event per observation. Likewise
+//
+//		metrics.Meter(name).Mark()
+//
+// sends the number of events along with their rate and its 1, 5 and 15
+// minute moving averages.
+//
+// Metrics can be checked against declarations of their kind, unit and tags
+// by passing a registry from package metric_registry to SetRegistry.
 package metrics
 
 import (
+	"github.com/cloudfoundry/dropsonde/metric_registry"
 	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
 	"github.com/cloudfoundry/sonde-go/events"
 )
 
@@ -54,22 +72,42 @@ type MetricBatcher interface {
 	Close()
 }
 
+// GaugeBatcher is implemented by MetricBatchers that can batch gauges, such
+// as *metricbatcher.MetricBatcher.
+type GaugeBatcher interface {
+	BatchSetGauge(name string, value float64, unit string)
+}
+
+// TagBatcher is implemented by MetricBatchers that can batch tagged metrics,
+// such as *metricbatcher.MetricBatcher.
+type TagBatcher interface {
+	BatchCounter(name string) metricbatcher.BatchCounterChainer
+	BatchGauge(name, unit string, aggregation metricbatcher.GaugeAggregation) metricbatcher.BatchGaugeChainer
+}
+
 // Initialize prepares the metrics package for use with the automatic Emitter.
 func Initialize(ms MetricSender, mb MetricBatcher) {
+	stopAggregation()
 	if metricBatcher != nil {
 		metricBatcher.Close()
 	}
 	metricSender = ms
 	metricBatcher = mb
+	startAggregation()
 }
 
-// Closes the metrics system and flushes any batch metrics.
+// Closes the metrics system and flushes any batch and aggregated metrics.
 func Close() {
+	stopAggregation()
+	FlushAggregates()
 	metricBatcher.Close()
 }
 
 // Send sends an events.Event.
 func Send(ev events.Event) error {
+	if err := checkEvent(ev); err != nil {
+		return err
+	}
 	return metricSender.Send(ev)
 }
 
@@ -79,6 +117,9 @@ func SendValue(name string, value float64, unit string) error {
 	if metricSender == nil {
 		return nil
 	}
+	if err := check(name, metric_registry.Gauge, unit); err != nil {
+		return err
+	}
 	return metricSender.SendValue(name, value, unit)
 }
 
@@ -89,6 +130,9 @@ func IncrementCounter(name string) error {
 	if metricSender == nil {
 		return nil
 	}
+	if err := check(name, metric_registry.Counter, ""); err != nil {
+		return err
+	}
 	return metricSender.IncrementCounter(name)
 }
 
@@ -96,7 +140,7 @@ func IncrementCounter(name string) error {
 // not emit a CounterEvent for each increment; instead, the increments are batched
 // and a single CounterEvent is sent after the timeout.
 func BatchIncrementCounter(name string) {
-	if metricBatcher == nil {
+	if metricBatcher == nil || !checkBatched(name, metric_registry.Counter, "") {
 		return
 	}
 	metricBatcher.BatchIncrementCounter(name)
@@ -109,6 +153,9 @@ func AddToCounter(name string, delta uint64) error {
 	if metricSender == nil {
 		return nil
 	}
+	if err := check(name, metric_registry.Counter, ""); err != nil {
+		return err
+	}
 	return metricSender.AddToCounter(name, delta)
 }
 
@@ -116,12 +163,24 @@ func AddToCounter(name string, delta uint64) error {
 // CounterEvent for each add; instead, the adds are batched and a single CounterEvent
 // is sent after the timeout.
 func BatchAddCounter(name string, delta uint64) {
-	if metricBatcher == nil {
+	if metricBatcher == nil || !checkBatched(name, metric_registry.Counter, "") {
 		return
 	}
 	metricBatcher.BatchAddCounter(name, delta)
 }
 
+// BatchSetGauge sets a gauge but, unlike SendValue, does not emit a
+// ValueMetric for each change; instead, the last value set is sent once after
+// the timeout. The gauge is dropped if the MetricBatcher is not a
+// GaugeBatcher.
+func BatchSetGauge(name string, value float64, unit string) {
+	gaugeBatcher, ok := metricBatcher.(GaugeBatcher)
+	if !ok || !checkBatched(name, metric_registry.Gauge, unit) {
+		return
+	}
+	gaugeBatcher.BatchSetGauge(name, value, unit)
+}
+
 // SendContainerMetric sends a metric that records resource usage of an app in a container.
 // The container is identified by the applicationId and the instanceIndex. The resource
 // metrics are CPU percentage, memory and disk usage in bytes. Returns an error if one occurs
@@ -140,6 +199,14 @@ func Value(name string, value float64, unit string) metric_sender.ValueChainer {
 	if metricSender == nil {
 		return nil
 	}
+	if currentRegistry() != nil {
+		return checkedValueChainer{
+			ValueChainer: metricSender.Value(name, value, unit),
+			name:         name,
+			unit:         unit,
+			err:          check(name, metric_registry.Gauge, unit),
+		}
+	}
 	return metricSender.Value(name, value, unit)
 }
 
@@ -158,5 +225,12 @@ func Counter(name string) metric_sender.CounterChainer {
 	if metricSender == nil {
 		return nil
 	}
+	if currentRegistry() != nil {
+		return checkedCounterChainer{
+			CounterChainer: metricSender.Counter(name),
+			name:           name,
+			err:            check(name, metric_registry.Counter, ""),
+		}
+	}
 	return metricSender.Counter(name)
 }
@@ -5,6 +5,8 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
 	"github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/sonde-go/events"
 )
@@ -77,6 +79,18 @@ var _ = Describe("Metrics", func() {
 		Eventually(metricBatcher.BatchAddCounterInput).Should(BeCalled(With("count", uint64(3))))
 	})
 
+	It("delegates BatchSetGauge", func() {
+		metrics.BatchSetGauge("queueDepth", 12, "count")
+		Eventually(metricBatcher.BatchSetGaugeInput).Should(BeCalled(With("queueDepth", 12.0, "count")))
+	})
+
+	It("drops batched gauges when the batcher cannot batch them", func() {
+		metrics.Initialize(metricSender, struct{ metrics.MetricBatcher }{metricBatcher})
+
+		metrics.BatchSetGauge("queueDepth", 12, "count")
+		Consistently(metricBatcher.BatchSetGaugeCalled).ShouldNot(Receive())
+	})
+
 	It("delegates SendContainerMetric", func() {
 		metricSender.SendContainerMetricOutput.Ret0 <- nil
 		appGuid := "some_app_guid"
@@ -130,6 +144,13 @@ var _ = Describe("Metrics", func() {
 	})
 
 	Context("Close", func() {
+		BeforeEach(func() {
+			// Close flushes aggregated metrics, which would block on the
+			// mock sender.
+			metricBatcher = newMockMetricBatcher()
+			metrics.Initialize(metric_sender.NewMetricSender(fake.NewFakeEventEmitter("origin")), metricBatcher)
+		})
+
 		It("closes metric batcher", func() {
 			metrics.Close()
 			Eventually(metricBatcher.CloseCalled).Should(BeCalled())
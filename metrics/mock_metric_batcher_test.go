@@ -5,6 +5,8 @@
 
 package metrics_test
 
+import "github.com/cloudfoundry/dropsonde/metricbatcher"
+
 type mockMetricBatcher struct {
 	BatchIncrementCounterCalled chan bool
 	BatchIncrementCounterInput  struct {
@@ -15,6 +17,28 @@ type mockMetricBatcher struct {
 		Name  chan string
 		Delta chan uint64
 	}
+	BatchSetGaugeCalled chan bool
+	BatchSetGaugeInput  struct {
+		Name  chan string
+		Value chan float64
+		Unit  chan string
+	}
+	BatchCounterCalled chan bool
+	BatchCounterInput  struct {
+		Name chan string
+	}
+	BatchCounterOutput struct {
+		Ret0 chan metricbatcher.BatchCounterChainer
+	}
+	BatchGaugeCalled chan bool
+	BatchGaugeInput  struct {
+		Name        chan string
+		Unit        chan string
+		Aggregation chan metricbatcher.GaugeAggregation
+	}
+	BatchGaugeOutput struct {
+		Ret0 chan metricbatcher.BatchGaugeChainer
+	}
 	CloseCalled chan bool
 }
 
@@ -25,6 +49,18 @@ func newMockMetricBatcher() *mockMetricBatcher {
 	m.BatchAddCounterCalled = make(chan bool, 100)
 	m.BatchAddCounterInput.Name = make(chan string, 100)
 	m.BatchAddCounterInput.Delta = make(chan uint64, 100)
+	m.BatchSetGaugeCalled = make(chan bool, 100)
+	m.BatchSetGaugeInput.Name = make(chan string, 100)
+	m.BatchSetGaugeInput.Value = make(chan float64, 100)
+	m.BatchSetGaugeInput.Unit = make(chan string, 100)
+	m.BatchCounterCalled = make(chan bool, 100)
+	m.BatchCounterInput.Name = make(chan string, 100)
+	m.BatchCounterOutput.Ret0 = make(chan metricbatcher.BatchCounterChainer, 100)
+	m.BatchGaugeCalled = make(chan bool, 100)
+	m.BatchGaugeInput.Name = make(chan string, 100)
+	m.BatchGaugeInput.Unit = make(chan string, 100)
+	m.BatchGaugeInput.Aggregation = make(chan metricbatcher.GaugeAggregation, 100)
+	m.BatchGaugeOutput.Ret0 = make(chan metricbatcher.BatchGaugeChainer, 100)
 	m.CloseCalled = make(chan bool, 100)
 	return m
 }
@@ -37,6 +73,24 @@ func (m *mockMetricBatcher) BatchAddCounter(name string, delta uint64) {
 	m.BatchAddCounterInput.Name <- name
 	m.BatchAddCounterInput.Delta <- delta
 }
+func (m *mockMetricBatcher) BatchSetGauge(name string, value float64, unit string) {
+	m.BatchSetGaugeCalled <- true
+	m.BatchSetGaugeInput.Name <- name
+	m.BatchSetGaugeInput.Value <- value
+	m.BatchSetGaugeInput.Unit <- unit
+}
+func (m *mockMetricBatcher) BatchCounter(name string) metricbatcher.BatchCounterChainer {
+	m.BatchCounterCalled <- true
+	m.BatchCounterInput.Name <- name
+	return <-m.BatchCounterOutput.Ret0
+}
+func (m *mockMetricBatcher) BatchGauge(name, unit string, aggregation metricbatcher.GaugeAggregation) metricbatcher.BatchGaugeChainer {
+	m.BatchGaugeCalled <- true
+	m.BatchGaugeInput.Name <- name
+	m.BatchGaugeInput.Unit <- unit
+	m.BatchGaugeInput.Aggregation <- aggregation
+	return <-m.BatchGaugeOutput.Ret0
+}
 func (m *mockMetricBatcher) Close() {
 	m.CloseCalled <- true
 }
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var (
+	registryLock sync.RWMutex
+	registry     *metric_registry.Registry
+)
+
+// SetRegistry makes the package check every metric it sends against the
+// declarations in r. Depending on the registry's mode, metrics that do not
+// match are logged or rejected. Passing nil turns checking off.
+func SetRegistry(r *metric_registry.Registry) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry = r
+}
+
+func currentRegistry() *metric_registry.Registry {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registry
+}
+
+func check(name string, kind metric_registry.Kind, unit string, tagKeys ...string) error {
+	r := currentRegistry()
+	if r == nil {
+		return nil
+	}
+	return r.Check(name, kind, unit, tagKeys...)
+}
+
+// checkEvent checks the events that name a metric. Other events, such as
+// container metrics, are not checked.
+func checkEvent(ev events.Event) error {
+	switch e := ev.(type) {
+	case *events.ValueMetric:
+		return check(e.GetName(), metric_registry.Gauge, e.GetUnit())
+	case *events.CounterEvent:
+		return check(e.GetName(), metric_registry.Counter, "")
+	}
+	return nil
+}
+
+// checkBatched checks a metric for the batching functions, which cannot
+// return an error, and logs it instead.
+func checkBatched(name string, kind metric_registry.Kind, unit string, tagKeys ...string) bool {
+	if err := check(name, kind, unit, tagKeys...); err != nil {
+		log.Printf("metrics: dropped %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+type checkedValueChainer struct {
+	metric_sender.ValueChainer
+	name, unit string
+	err        error
+}
+
+func (c checkedValueChainer) SetTag(key, value string) metric_sender.ValueChainer {
+	if c.err == nil {
+		c.err = check(c.name, metric_registry.Gauge, c.unit, key)
+	}
+	c.ValueChainer = c.ValueChainer.SetTag(key, value)
+	return c
+}
+
+func (c checkedValueChainer) Send() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.ValueChainer.Send()
+}
+
+func (c checkedValueChainer) SendContext(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	if chainer, ok := c.ValueChainer.(metric_sender.ContextValueChainer); ok {
+		return chainer.SendContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.ValueChainer.Send()
+}
+
+type checkedCounterChainer struct {
+	metric_sender.CounterChainer
+	name string
+	err  error
+}
+
+func (c checkedCounterChainer) SetTag(key, value string) metric_sender.CounterChainer {
+	if c.err == nil {
+		c.err = check(c.name, metric_registry.Counter, "", key)
+	}
+	c.CounterChainer = c.CounterChainer.SetTag(key, value)
+	return c
+}
+
+func (c checkedCounterChainer) Increment() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.CounterChainer.Increment()
+}
+
+func (c checkedCounterChainer) IncrementContext(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	if chainer, ok := c.CounterChainer.(metric_sender.ContextCounterChainer); ok {
+		return chainer.IncrementContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.CounterChainer.Increment()
+}
+
+func (c checkedCounterChainer) Add(delta uint64) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.CounterChainer.Add(delta)
+}
+
+func (c checkedCounterChainer) AddContext(ctx context.Context, delta uint64) error {
+	if c.err != nil {
+		return c.err
+	}
+	if chainer, ok := c.CounterChainer.(metric_sender.ContextCounterChainer); ok {
+		return chainer.AddContext(ctx, delta)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.CounterChainer.Add(delta)
+}
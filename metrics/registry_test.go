@@ -0,0 +1,118 @@
+package metrics_test
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var (
+		fakeEmitter *fake.FakeEventEmitter
+		registry    *metric_registry.Registry
+	)
+
+	var declare = func(mode metric_registry.Mode) {
+		registry = metric_registry.New(mode)
+		registry.MustRegister(
+			metric_registry.Descriptor{Name: "requests", Kind: metric_registry.Counter, TagKeys: []string{"route"}},
+			metric_registry.Descriptor{Name: "queueDepth", Kind: metric_registry.Gauge, Unit: "count"},
+			metric_registry.Descriptor{Name: "checkedLatency", Kind: metric_registry.Histogram, Unit: "ms"},
+		)
+		metrics.SetRegistry(registry)
+	}
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		metrics.Initialize(metric_sender.NewMetricSender(fakeEmitter), newMockMetricBatcher())
+	})
+
+	AfterEach(func() {
+		metrics.SetRegistry(nil)
+	})
+
+	Context("in strict mode", func() {
+		BeforeEach(func() {
+			declare(metric_registry.Strict)
+		})
+
+		It("sends declared metrics", func() {
+			Expect(metrics.SendValue("queueDepth", 3, "count")).To(Succeed())
+			Expect(metrics.IncrementCounter("requests")).To(Succeed())
+			Expect(metrics.Counter("requests").SetTag("route", "/").Increment()).To(Succeed())
+			Expect(metrics.Value("queueDepth", 3, "count").Send()).To(Succeed())
+
+			Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(4))
+		})
+
+		It("rejects undeclared metrics", func() {
+			Expect(metrics.SendValue("undeclared", 3, "count")).ToNot(Succeed())
+			Expect(metrics.AddToCounter("undeclared", 2)).ToNot(Succeed())
+			Expect(metrics.Counter("undeclared").Increment()).ToNot(Succeed())
+			Expect(metrics.Send(&events.CounterEvent{Name: proto.String("undeclared"), Delta: proto.Uint64(1)})).ToNot(Succeed())
+
+			Expect(fakeEmitter.GetEnvelopes()).To(BeEmpty())
+			Expect(fakeEmitter.GetMessages()).To(BeEmpty())
+		})
+
+		It("rejects metrics sent as another kind or with another unit", func() {
+			Expect(metrics.SendValue("requests", 1, "count")).To(MatchError(ContainSubstring("declared as a counter")))
+			Expect(metrics.Value("queueDepth", 3, "bytes").Send()).To(MatchError(ContainSubstring(`declared with unit "count"`)))
+		})
+
+		It("rejects undeclared tags", func() {
+			err := metrics.Counter("requests").SetTag("user", "42").Increment()
+			Expect(err).To(MatchError(ContainSubstring(`undeclared tag "user"`)))
+			Expect(fakeEmitter.GetEnvelopes()).To(BeEmpty())
+		})
+
+		It("drops undeclared batched metrics", func() {
+			log.SetOutput(new(bytes.Buffer))
+			batcher := newMockMetricBatcher()
+			metrics.Initialize(metric_sender.NewMetricSender(fakeEmitter), batcher)
+
+			metrics.BatchIncrementCounter("undeclared")
+			metrics.BatchIncrementCounter("requests")
+
+			Expect(batcher.BatchIncrementCounterInput.Name).To(Receive(Equal("requests")))
+			Expect(batcher.BatchIncrementCounterInput.Name).ToNot(Receive())
+		})
+
+		It("checks histograms when they are flushed", func() {
+			log.SetOutput(new(bytes.Buffer))
+			metrics.Histogram("checkedLatency", nil).WithUnit("s").Observe(1)
+			metrics.FlushAggregates()
+			Expect(fakeEmitter.GetEnvelopes()).To(BeEmpty())
+
+			metrics.Histogram("checkedLatency", nil).WithUnit("ms").Observe(1)
+			metrics.FlushAggregates()
+			Expect(fakeEmitter.GetEnvelopes()).ToNot(BeEmpty())
+		})
+	})
+
+	Context("in warn mode", func() {
+		var logOutput *bytes.Buffer
+
+		BeforeEach(func() {
+			declare(metric_registry.Warn)
+			logOutput = new(bytes.Buffer)
+			log.SetOutput(logOutput)
+		})
+
+		It("logs and sends undeclared metrics", func() {
+			Expect(metrics.Counter("undeclared").Increment()).To(Succeed())
+
+			Expect(fakeEmitter.GetEnvelopes()).To(HaveLen(1))
+			Expect(logOutput.String()).To(ContainSubstring(`"undeclared" is not declared`))
+		})
+	})
+})
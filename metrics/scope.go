@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
+)
+
+// A Scope sends metrics through the package-level sender and batcher with a
+// name prefix and tags applied to every metric. Tags set on a chainer take
+// precedence over those of the scope. Batched metrics with tags are dropped
+// if the MetricBatcher is not a TagBatcher. Scopes are immutable and safe for
+// concurrent use.
+type Scope struct {
+	prefix string
+	tags   map[string]string
+	keys   []string
+}
+
+// WithTags returns a Scope that adds the given tags to every metric.
+func WithTags(tags map[string]string) *Scope {
+	return (&Scope{}).WithTags(tags)
+}
+
+// WithPrefix returns a Scope that prepends prefix to the name of every metric.
+func WithPrefix(prefix string) *Scope {
+	return (&Scope{}).WithPrefix(prefix)
+}
+
+// WithTags returns a Scope that adds the given tags to those of s, replacing
+// tags with the same key.
+func (s *Scope) WithTags(tags map[string]string) *Scope {
+	merged := make(map[string]string, len(s.tags)+len(tags))
+	for k, v := range s.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &Scope{prefix: s.prefix, tags: merged, keys: keys}
+}
+
+// WithPrefix returns a Scope that appends prefix to the prefix of s.
+func (s *Scope) WithPrefix(prefix string) *Scope {
+	return &Scope{prefix: s.prefix + prefix, tags: s.tags, keys: s.keys}
+}
+
+// SendValue sends a value event for the named metric.
+func (s *Scope) SendValue(name string, value float64, unit string) error {
+	c := s.Value(name, value, unit)
+	if c == nil {
+		return nil
+	}
+	return c.Send()
+}
+
+// IncrementCounter sends an event to increment the named counter by one.
+func (s *Scope) IncrementCounter(name string) error {
+	c := s.Counter(name)
+	if c == nil {
+		return nil
+	}
+	return c.Increment()
+}
+
+// AddToCounter sends an event to increment the named counter by delta.
+func (s *Scope) AddToCounter(name string, delta uint64) error {
+	c := s.Counter(name)
+	if c == nil {
+		return nil
+	}
+	return c.Add(delta)
+}
+
+// Value creates a value metric with the scope's prefix and tags that can be
+// manipulated via cascading calls and then sent.
+func (s *Scope) Value(name string, value float64, unit string) metric_sender.ValueChainer {
+	c := Value(s.prefix+name, value, unit)
+	if c == nil {
+		return nil
+	}
+	for _, k := range s.keys {
+		c = c.SetTag(k, s.tags[k])
+	}
+	return c
+}
+
+// Counter creates a counter event with the scope's prefix and tags that can
+// be manipulated via cascading calls and then sent via Increment or Add.
+func (s *Scope) Counter(name string) metric_sender.CounterChainer {
+	c := Counter(s.prefix + name)
+	if c == nil {
+		return nil
+	}
+	for _, k := range s.keys {
+		c = c.SetTag(k, s.tags[k])
+	}
+	return c
+}
+
+// BatchIncrementCounter increments the named counter in the batcher.
+func (s *Scope) BatchIncrementCounter(name string) {
+	s.BatchAddCounter(name, 1)
+}
+
+// BatchAddCounter adds delta to the named counter in the batcher.
+func (s *Scope) BatchAddCounter(name string, delta uint64) {
+	name = s.prefix + name
+	if len(s.keys) == 0 {
+		BatchAddCounter(name, delta)
+		return
+	}
+	tagBatcher, ok := metricBatcher.(TagBatcher)
+	if !ok || !checkBatched(name, metric_registry.Counter, "", s.keys...) {
+		return
+	}
+
+	c := tagBatcher.BatchCounter(name)
+	for _, k := range s.keys {
+		c = c.SetTag(k, s.tags[k])
+	}
+	c.Add(delta)
+}
+
+// BatchSetGauge sets the named gauge in the batcher.
+func (s *Scope) BatchSetGauge(name string, value float64, unit string) {
+	name = s.prefix + name
+	if len(s.keys) == 0 {
+		BatchSetGauge(name, value, unit)
+		return
+	}
+	tagBatcher, ok := metricBatcher.(TagBatcher)
+	if !ok || !checkBatched(name, metric_registry.Gauge, unit, s.keys...) {
+		return
+	}
+
+	c := tagBatcher.BatchGauge(name, unit, metricbatcher.GaugeLast)
+	for _, k := range s.keys {
+		c = c.SetTag(k, s.tags[k])
+	}
+	c.Set(value)
+}
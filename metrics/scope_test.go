@@ -0,0 +1,113 @@
+package metrics_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metricbatcher"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scope", func() {
+	var (
+		fakeEmitter *fake.FakeEventEmitter
+		sender      *metric_sender.MetricSender
+		scope       *metrics.Scope
+	)
+
+	// batched returns the envelopes of the scope's metrics, leaving out the
+	// aggregated metrics of other specs that Close flushes.
+	var batched = func() []*events.Envelope {
+		var envelopes []*events.Envelope
+		for _, envelope := range fakeEmitter.GetEnvelopes() {
+			name := envelope.GetCounterEvent().GetName() + envelope.GetValueMetric().GetName()
+			if strings.HasPrefix(name, "router.") {
+				envelopes = append(envelopes, envelope)
+			}
+		}
+		return envelopes
+	}
+
+	BeforeEach(func() {
+		fakeEmitter = fake.NewFakeEventEmitter("origin")
+		sender = metric_sender.NewMetricSender(fakeEmitter)
+		metrics.Initialize(sender, metricbatcher.New(sender, time.Hour))
+		scope = metrics.WithPrefix("router.").WithTags(map[string]string{"az": "z1"})
+	})
+
+	It("applies the prefix and tags to sent metrics", func() {
+		Expect(scope.SendValue("latency", 1, "ms")).To(Succeed())
+		Expect(scope.IncrementCounter("requests")).To(Succeed())
+		Expect(scope.AddToCounter("bytes", 10)).To(Succeed())
+		Expect(scope.Value("latency", 2, "ms").SetTag("route", "/").Send()).To(Succeed())
+
+		envelopes := fakeEmitter.GetEnvelopes()
+		Expect(envelopes).To(HaveLen(4))
+		Expect(envelopes[0].GetValueMetric().GetName()).To(Equal("router.latency"))
+		Expect(envelopes[1].GetCounterEvent().GetName()).To(Equal("router.requests"))
+		Expect(envelopes[2].GetCounterEvent().GetDelta()).To(BeEquivalentTo(10))
+		for _, envelope := range envelopes {
+			Expect(envelope.GetTags()).To(HaveKeyWithValue("az", "z1"))
+		}
+		Expect(envelopes[3].GetTags()).To(HaveKeyWithValue("route", "/"))
+	})
+
+	It("applies the prefix and tags to batched metrics", func() {
+		scope.BatchIncrementCounter("requests")
+		scope.BatchAddCounter("requests", 2)
+		scope.BatchSetGauge("connections", 5, "count")
+		metrics.WithPrefix("router.").BatchIncrementCounter("untagged")
+		metrics.Close()
+
+		Expect(batched()).To(HaveLen(3))
+		counters := make(map[string]uint64)
+		for _, envelope := range batched() {
+			if envelope.GetCounterEvent() != nil {
+				counters[envelope.GetCounterEvent().GetName()] = envelope.GetCounterEvent().GetDelta()
+				continue
+			}
+			Expect(envelope.GetValueMetric().GetName()).To(Equal("router.connections"))
+			Expect(envelope.GetTags()).To(HaveKeyWithValue("az", "z1"))
+		}
+		Expect(counters).To(Equal(map[string]uint64{"router.requests": 3, "router.untagged": 1}))
+	})
+
+	It("drops tagged batched metrics when the batcher cannot batch them", func() {
+		metrics.Initialize(sender, struct{ metrics.MetricBatcher }{metricbatcher.New(sender, time.Hour)})
+
+		scope.BatchIncrementCounter("requests")
+		metrics.WithPrefix("router.").BatchIncrementCounter("untagged")
+		metrics.Close()
+
+		Expect(batched()).To(HaveLen(1))
+		Expect(batched()[0].GetCounterEvent().GetName()).To(Equal("router.untagged"))
+	})
+
+	It("merges nested scopes", func() {
+		nested := scope.WithPrefix("http.").WithTags(map[string]string{"az": "z2", "env": "prod"})
+		Expect(nested.IncrementCounter("requests")).To(Succeed())
+
+		envelope := fakeEmitter.GetEnvelopes()[0]
+		Expect(envelope.GetCounterEvent().GetName()).To(Equal("router.http.requests"))
+		Expect(envelope.GetTags()).To(HaveKeyWithValue("az", "z2"))
+		Expect(envelope.GetTags()).To(HaveKeyWithValue("env", "prod"))
+	})
+
+	It("applies the tag limit to the merged tags", func() {
+		Expect(sender.SetDefaultTags(map[string]string{"deployment": "cf"})).To(Succeed())
+		tags := make(map[string]string)
+		for i := 0; i < 9; i++ {
+			tags[fmt.Sprintf("key-%d", i)] = "value"
+		}
+
+		Expect(metrics.WithTags(tags).SendValue("latency", 1, "ms")).To(Succeed())
+		Expect(metrics.WithTags(tags).Value("latency", 1, "ms").SetTag("route", "/").Send()).To(MatchError("Too many tags. Max of 10"))
+	})
+})
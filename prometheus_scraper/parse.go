@@ -0,0 +1,180 @@
+package prometheus_scraper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metric types of the text exposition format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+	typeUntyped   = "untyped"
+)
+
+// sample is a single line of the text exposition format.
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+	// timestamp is in milliseconds since the Unix epoch, or 0 if the sample
+	// has none.
+	timestamp int64
+	// kind is the type declared for the sample's metric family.
+	kind string
+}
+
+// parse reads samples in the Prometheus text exposition format, version
+// 0.0.4.
+func parse(r io.Reader) ([]sample, error) {
+	types := make(map[string]string)
+	var samples []sample
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		s.kind = kindOf(types, s.name)
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// kindOf returns the type of the family the named sample belongs to. The
+// samples of histograms and summaries carry a suffix after the family name.
+func kindOf(types map[string]string, name string) string {
+	if kind, ok := types[name]; ok {
+		return kind
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		kind := types[strings.TrimSuffix(name, suffix)]
+		if kind == typeHistogram || (kind == typeSummary && suffix != "_bucket") {
+			return kind
+		}
+	}
+	return typeUntyped
+}
+
+func parseSample(line string) (sample, error) {
+	var s sample
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	s.name = line[:end]
+	rest := line[end:]
+
+	if rest[0] == '{' {
+		labels, remainder, err := parseLabels(rest[1:])
+		if err != nil {
+			return s, err
+		}
+		s.labels = labels
+		rest = remainder
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value %q", fields[0])
+	}
+	s.value = value
+	if len(fields) == 2 {
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return s, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		s.timestamp = timestamp
+	}
+	return s, nil
+}
+
+// parseLabels parses the labels following the opening brace, and returns
+// the rest of the line after the closing brace.
+func parseLabels(text string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	for {
+		text = strings.TrimLeft(text, " \t")
+		if strings.HasPrefix(text, "}") {
+			return labels, text[1:], nil
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq <= 0 {
+			return nil, "", fmt.Errorf("invalid labels")
+		}
+		name := strings.TrimSpace(text[:eq])
+		text = strings.TrimLeft(text[eq+1:], " \t")
+		if !strings.HasPrefix(text, `"`) {
+			return nil, "", fmt.Errorf("unquoted value of label %q", name)
+		}
+
+		value, n, err := unquote(text[1:])
+		if err != nil {
+			return nil, "", fmt.Errorf("label %q: %v", name, err)
+		}
+		labels[name] = value
+		text = strings.TrimLeft(text[1+n:], " \t")
+		text = strings.TrimPrefix(text, ",")
+	}
+}
+
+// unquote reads a label value up to its closing quote, resolving the \\, \"
+// and \n escapes. It returns the value and the number of bytes read,
+// including the closing quote.
+func unquote(text string) (string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(text) {
+				return "", 0, fmt.Errorf("unterminated value")
+			}
+			switch text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case '\\', '"':
+				b.WriteByte(text[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated value")
+}
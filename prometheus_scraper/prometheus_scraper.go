@@ -0,0 +1,210 @@
+// Package prometheus_scraper periodically scrapes an endpoint that exposes
+// metrics in the Prometheus text format, and sends them as dropsonde
+// envelopes through package envelopes.
+//
+// Use
+//
+//	scraper := prometheus_scraper.New("http://localhost:9100/metrics", "node-exporter", 15*time.Second)
+//	go scraper.Run(stopChan)
+//
+// Labels are sent as tags. Samples with more labels, or longer labels, than
+// envelopes may carry as tags are skipped and logged. Gauges and untyped metrics are sent as
+// ValueMetrics. Counters are sent as CounterEvents with the change since the
+// previous scrape as Delta and the value of the counter as Total; a decrease
+// is treated as a reset of the counter. The first scrape of a counter sends
+// a Delta of zero, as its earlier changes are unknown. Histograms are sent bucket by
+// bucket, as CounterEvents named name_bucket with an "le" tag, along with the
+// name_count CounterEvent and the name_sum ValueMetric. Summaries are sent as
+// ValueMetrics with a "quantile" tag, along with their count and sum.
+//
+// Prometheus does not carry units, so every ValueMetric is sent with the unit
+// "count".
+package prometheus_scraper
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/envelopes"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/sonde-go/events"
+	"google.golang.org/protobuf/proto"
+)
+
+const acceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// An Option configures a Scraper created by New.
+type Option func(*Scraper)
+
+// WithClient makes the Scraper use client for its requests instead of a
+// client with a timeout of the scrape interval.
+func WithClient(client *http.Client) Option {
+	return func(s *Scraper) {
+		s.client = client
+	}
+}
+
+// WithPrefix prepends prefix to the name of every metric.
+func WithPrefix(prefix string) Option {
+	return func(s *Scraper) {
+		s.prefix = prefix
+	}
+}
+
+// A Scraper converts the metrics exposed by an endpoint into envelopes.
+type Scraper struct {
+	url      string
+	origin   string
+	interval time.Duration
+	client   *http.Client
+	prefix   string
+
+	// counters holds the value of each counter series at the previous
+	// scrape. Series that are missing from a scrape are forgotten.
+	counters map[string]float64
+}
+
+// New creates a Scraper that scrapes url every interval and sends the
+// envelopes with the given origin.
+func New(url, origin string, interval time.Duration, opts ...Option) *Scraper {
+	s := &Scraper{
+		url:      url,
+		origin:   origin,
+		interval: interval,
+		client:   &http.Client{Timeout: interval},
+		counters: make(map[string]float64),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run scrapes the endpoint every interval until stopChan is closed. Errors
+// are logged.
+func (s *Scraper) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Scrape(); err != nil {
+			log.Printf("PrometheusScraper: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// Scrape fetches the metrics once and sends them. Nothing is sent if the
+// response cannot be parsed. Otherwise it returns the first error that
+// occurred, after attempting to send every metric.
+func (s *Scraper) Scrape() error {
+	samples, err := s.fetch()
+	if err != nil {
+		return fmt.Errorf("failed to scrape %s: %v", s.url, err)
+	}
+
+	now := time.Now().UnixNano()
+	counters := make(map[string]float64, len(s.counters))
+	var firstErr error
+	for _, sample := range samples {
+		if err := metric_sender.ValidateTags(sample.labels); err != nil {
+			log.Printf("PrometheusScraper: skipped %s: %v", sample.name, err)
+			continue
+		}
+
+		envelope := s.convert(sample, counters)
+		if envelope == nil {
+			continue
+		}
+		envelope.Origin = proto.String(s.origin)
+		envelope.Timestamp = proto.Int64(now)
+		if sample.timestamp != 0 {
+			envelope.Timestamp = proto.Int64(sample.timestamp * int64(time.Millisecond))
+		}
+		if len(sample.labels) > 0 {
+			envelope.Tags = sample.labels
+		}
+
+		if err := envelopes.SendEnvelope(envelope); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.counters = counters
+	return firstErr
+}
+
+func (s *Scraper) fetch() ([]sample, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return parse(resp.Body)
+}
+
+// convert returns the envelope for a sample, or nil if nothing is to be
+// sent for it. The values of counters are recorded in counters.
+func (s *Scraper) convert(sample sample, counters map[string]float64) *events.Envelope {
+	name := s.prefix + sample.name
+	switch {
+	case sample.kind == typeCounter,
+		sample.kind == typeHistogram && !strings.HasSuffix(sample.name, "_sum"),
+		sample.kind == typeSummary && strings.HasSuffix(sample.name, "_count"):
+		return s.counter(name, sample, counters)
+	}
+	return &events.Envelope{
+		EventType:   events.Envelope_ValueMetric.Enum(),
+		ValueMetric: factories.NewValueMetric(name, sample.value, "count"),
+	}
+}
+
+// counter returns the CounterEvent for the change of a counter since the
+// previous scrape, and records its value in counters. The first scrape of a
+// counter only reports its total. A decrease is treated as a reset, after
+// which the whole value is sent. Fractions of counters with non-integer
+// values are carried over to later scrapes.
+func (s *Scraper) counter(name string, sample sample, counters map[string]float64) *events.Envelope {
+	if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) || sample.value < 0 {
+		return nil
+	}
+
+	key := metric_sender.SeriesKey(sample.name, sample.labels)
+	previous, seen := s.counters[key]
+	counters[key] = sample.value
+	switch {
+	case !seen:
+		previous = sample.value
+	case sample.value < previous:
+		previous = 0
+	}
+	delta := uint64(math.Floor(sample.value)) - uint64(math.Floor(previous))
+	if seen && delta == 0 {
+		return nil
+	}
+
+	counter := factories.NewCounterEvent(name, delta)
+	counter.Total = proto.Uint64(uint64(math.Floor(sample.value)))
+	return &events.Envelope{
+		EventType:    events.Envelope_CounterEvent.Enum(),
+		CounterEvent: counter,
+	}
+}
@@ -0,0 +1,13 @@
+package prometheus_scraper_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPrometheusScraper(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PrometheusScraper Suite")
+}
@@ -0,0 +1,238 @@
+package prometheus_scraper_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/envelope_sender/fake"
+	"github.com/cloudfoundry/dropsonde/envelopes"
+	"github.com/cloudfoundry/dropsonde/prometheus_scraper"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scraper", func() {
+	var (
+		fakeSender *fake.FakeEnvelopeSender
+		server     *httptest.Server
+		scraper    *prometheus_scraper.Scraper
+
+		lock   sync.Mutex
+		body   string
+		status int
+		accept string
+	)
+
+	var serve = func(b string) {
+		lock.Lock()
+		defer lock.Unlock()
+		body = b
+	}
+
+	var values = func() map[string]*events.Envelope {
+		values := make(map[string]*events.Envelope)
+		for _, envelope := range fakeSender.GetEnvelopes() {
+			if metric := envelope.GetValueMetric(); metric != nil {
+				values[fmt.Sprintf("%s %v", metric.GetName(), envelope.GetTags())] = envelope
+			}
+		}
+		return values
+	}
+
+	var counters = func() map[string][]*events.CounterEvent {
+		counters := make(map[string][]*events.CounterEvent)
+		for _, envelope := range fakeSender.GetEnvelopes() {
+			if counter := envelope.GetCounterEvent(); counter != nil {
+				key := fmt.Sprintf("%s %v", counter.GetName(), envelope.GetTags())
+				counters[key] = append(counters[key], counter)
+			}
+		}
+		return counters
+	}
+
+	var deltas = func(key string) []uint64 {
+		var deltas []uint64
+		for _, counter := range counters()[key] {
+			deltas = append(deltas, counter.GetDelta())
+		}
+		return deltas
+	}
+
+	var totals = func(key string) []uint64 {
+		var totals []uint64
+		for _, counter := range counters()[key] {
+			totals = append(totals, counter.GetTotal())
+		}
+		return totals
+	}
+
+	BeforeEach(func() {
+		fakeSender = fake.NewFakeEnvelopeSender()
+		envelopes.Initialize(fakeSender)
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			accept = r.Header.Get("Accept")
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		}))
+		scraper = prometheus_scraper.New(server.URL+"/metrics", "sidecar", time.Hour)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		envelopes.Initialize(nil)
+	})
+
+	It("sends gauges and untyped metrics as values with labels as tags", func() {
+		serve(`# HELP queue_depth Jobs waiting.
+# TYPE queue_depth gauge
+queue_depth{queue="jobs",host="a\"b\\c"} 3.5
+temperature 21 1700000000000
+`)
+
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(accept).To(ContainSubstring("text/plain;version=0.0.4"))
+		Expect(fakeSender.GetEnvelopes()).To(HaveLen(2))
+		depth := values()[`queue_depth map[host:a"b\c queue:jobs]`]
+		Expect(depth.GetOrigin()).To(Equal("sidecar"))
+		Expect(depth.GetValueMetric().GetValue()).To(Equal(3.5))
+		Expect(depth.GetValueMetric().GetUnit()).To(Equal("count"))
+
+		temperature := values()["temperature map[]"]
+		Expect(temperature.GetValueMetric().GetValue()).To(Equal(21.0))
+		Expect(temperature.GetTimestamp()).To(Equal(int64(1700000000000) * int64(time.Millisecond)))
+	})
+
+	It("sends counters as deltas since the previous scrape", func() {
+		serve("# TYPE requests_total counter\nrequests_total{code=\"200\"} 10\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		serve("# TYPE requests_total counter\nrequests_total{code=\"200\"} 15\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(deltas("requests_total map[code:200]")).To(Equal([]uint64{0, 5}))
+		Expect(totals("requests_total map[code:200]")).To(Equal([]uint64{10, 15}))
+	})
+
+	It("forgets counters that are missing from a scrape", func() {
+		serve("# TYPE requests_total counter\nrequests_total{code=\"500\"} 3\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		serve("up 1\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		serve("# TYPE requests_total counter\nrequests_total{code=\"500\"} 7\n")
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(deltas("requests_total map[code:500]")).To(Equal([]uint64{0, 0}))
+		Expect(totals("requests_total map[code:500]")).To(Equal([]uint64{3, 7}))
+	})
+
+	It("detects counter resets", func() {
+		serve("# TYPE requests_total counter\nrequests_total 10\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		serve("# TYPE requests_total counter\nrequests_total 4\n")
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(deltas("requests_total map[]")).To(Equal([]uint64{0, 4}))
+	})
+
+	It("carries fractions of counters over to later scrapes", func() {
+		serve("# TYPE cpu_seconds_total counter\ncpu_seconds_total 1.5\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		serve("# TYPE cpu_seconds_total counter\ncpu_seconds_total 2.25\n")
+		Expect(scraper.Scrape()).To(Succeed())
+		serve("# TYPE cpu_seconds_total counter\ncpu_seconds_total 3.0\n")
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(deltas("cpu_seconds_total map[]")).To(Equal([]uint64{0, 1, 1}))
+	})
+
+	It("sends histograms as bucket counters, a count and a sum", func() {
+		serve(`# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 5
+latency_seconds_bucket{le="+Inf"} 6
+latency_seconds_sum 3.25
+latency_seconds_count 6
+`)
+
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(totals("latency_seconds_bucket map[le:0.1]")).To(Equal([]uint64{2}))
+		Expect(totals("latency_seconds_bucket map[le:1]")).To(Equal([]uint64{5}))
+		Expect(totals("latency_seconds_bucket map[le:+Inf]")).To(Equal([]uint64{6}))
+		Expect(totals("latency_seconds_count map[]")).To(Equal([]uint64{6}))
+		Expect(values()["latency_seconds_sum map[]"].GetValueMetric().GetValue()).To(Equal(3.25))
+	})
+
+	It("sends summaries as quantile values, a count and a sum", func() {
+		serve(`# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.2
+rpc_seconds{quantile="0.99"} 0.9
+rpc_seconds_sum 12
+rpc_seconds_count 40
+`)
+
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(values()["rpc_seconds map[quantile:0.99]"].GetValueMetric().GetValue()).To(Equal(0.9))
+		Expect(values()["rpc_seconds_sum map[]"].GetValueMetric().GetValue()).To(Equal(12.0))
+		Expect(totals("rpc_seconds_count map[]")).To(Equal([]uint64{40}))
+	})
+
+	It("skips samples with labels that exceed the limits on tags", func() {
+		serve(`many{a="1",b="2",c="3",d="4",e="5",f="6",g="7",h="8",i="9",j="10",k="11"} 1
+long{a="` + strings.Repeat("x", 257) + `"} 1
+up 1
+`)
+
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(fakeSender.GetEnvelopes()).To(HaveLen(1))
+		Expect(values()).To(HaveKey("up map[]"))
+	})
+
+	It("prefixes names", func() {
+		scraper = prometheus_scraper.New(server.URL, "sidecar", time.Hour, prometheus_scraper.WithPrefix("sidecar."))
+		serve("up 1\n")
+
+		Expect(scraper.Scrape()).To(Succeed())
+
+		Expect(values()).To(HaveKey("sidecar.up map[]"))
+	})
+
+	It("returns an error and sends nothing if the response is malformed", func() {
+		serve("up 1\nbroken{le=\"1} 2\n")
+
+		Expect(scraper.Scrape()).To(MatchError(ContainSubstring("line 2")))
+		Expect(fakeSender.GetEnvelopes()).To(BeEmpty())
+	})
+
+	It("returns an error for an unsuccessful response", func() {
+		status = http.StatusServiceUnavailable
+
+		Expect(scraper.Scrape()).To(MatchError(ContainSubstring("503")))
+	})
+
+	It("scrapes on an interval", func() {
+		serve("up 1\n")
+		scraper = prometheus_scraper.New(server.URL, "sidecar", 10*time.Millisecond)
+		stopChan, runDone := make(chan struct{}), make(chan struct{})
+		go func() {
+			scraper.Run(stopChan)
+			close(runDone)
+		}()
+
+		Eventually(func() int { return len(fakeSender.GetEnvelopes()) }).Should(BeNumerically(">=", 2))
+		close(stopChan)
+		Eventually(runDone).Should(BeClosed())
+	})
+})
@@ -0,0 +1,105 @@
+package runtime_stats
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/units"
+)
+
+// Tags set on heartbeat metrics from the build information of the binary.
+const (
+	ModuleVersionTag = "module_version"
+	VCSRevisionTag   = "vcs_revision"
+	GoVersionTag     = "go_version"
+)
+
+// processStart approximates the time the process started.
+var processStart = time.Now()
+
+type MetricSender interface {
+	Value(name string, value float64, unit string) metric_sender.ValueChainer
+	Counter(name string) metric_sender.CounterChainer
+}
+
+// Heartbeat periodically emits the uptime of the process, as the "uptime"
+// ValueMetric in seconds, and increments the "heartbeat" counter, so that
+// the build each instance runs can be told from its telemetry. Both are
+// tagged with BuildInfoTags and the version tags given to NewHeartbeat.
+type Heartbeat struct {
+	sender   MetricSender
+	interval time.Duration
+	tags     map[string]string
+}
+
+// NewHeartbeat creates a Heartbeat. The versionTags are added to the build
+// information tags, and take precedence over them.
+func NewHeartbeat(sender MetricSender, interval time.Duration, versionTags map[string]string) *Heartbeat {
+	tags := BuildInfoTags()
+	for k, v := range versionTags {
+		tags[k] = v
+	}
+	return &Heartbeat{
+		sender:   sender,
+		interval: interval,
+		tags:     tags,
+	}
+}
+
+// BuildInfoTags returns the module version, VCS revision and Go version the
+// binary was built with, keyed by ModuleVersionTag, VCSRevisionTag and
+// GoVersionTag. Information that is not available is left out.
+func BuildInfoTags() map[string]string {
+	tags := make(map[string]string)
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return tags
+	}
+
+	if info.Main.Version != "" {
+		tags[ModuleVersionTag] = info.Main.Version
+	}
+	if info.GoVersion != "" {
+		tags[GoVersionTag] = info.GoVersion
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			tags[VCSRevisionTag] = setting.Value
+		}
+	}
+	return tags
+}
+
+// Run emits the heartbeat every interval until stopChan is closed. Errors
+// are logged.
+func (h *Heartbeat) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.beat()
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (h *Heartbeat) beat() {
+	uptime := h.sender.Value("uptime", time.Since(processStart).Seconds(), units.Seconds)
+	heartbeat := h.sender.Counter("heartbeat")
+	for k, v := range h.tags {
+		uptime = uptime.SetTag(k, v)
+		heartbeat = heartbeat.SetTag(k, v)
+	}
+
+	if err := uptime.Send(); err != nil {
+		log.Printf("Heartbeat: failed to emit: %v", err)
+	}
+	if err := heartbeat.Increment(); err != nil {
+		log.Printf("Heartbeat: failed to emit: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package runtime_stats_test
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/runtime_stats"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Heartbeat", func() {
+	var (
+		fakeEventEmitter  *fake.FakeEventEmitter
+		stopChan, runDone chan struct{}
+	)
+
+	BeforeEach(func() {
+		fakeEventEmitter = fake.NewFakeEventEmitter("fake-origin")
+		stopChan = make(chan struct{})
+		runDone = make(chan struct{})
+
+		heartbeat := runtime_stats.NewHeartbeat(metric_sender.NewMetricSender(fakeEventEmitter), 10*time.Millisecond, map[string]string{
+			"release":                  "v42",
+			runtime_stats.GoVersionTag: "overridden",
+		})
+		go func() {
+			heartbeat.Run(stopChan)
+			close(runDone)
+		}()
+	})
+
+	AfterEach(func() {
+		close(stopChan)
+		Eventually(runDone).Should(BeClosed())
+	})
+
+	var envelopesOf = func(eventType events.Envelope_EventType) []*events.Envelope {
+		var envelopes []*events.Envelope
+		for _, envelope := range fakeEventEmitter.GetEnvelopes() {
+			if envelope.GetEventType() == eventType {
+				envelopes = append(envelopes, envelope)
+			}
+		}
+		return envelopes
+	}
+
+	It("periodically emits the uptime and increments the heartbeat", func() {
+		Eventually(func() int { return len(envelopesOf(events.Envelope_CounterEvent)) }).Should(BeNumerically(">=", 2))
+
+		counter := envelopesOf(events.Envelope_CounterEvent)[1].GetCounterEvent()
+		Expect(counter.GetName()).To(Equal("heartbeat"))
+		Expect(counter.GetDelta()).To(BeEquivalentTo(1))
+		Expect(counter.GetTotal()).To(BeEquivalentTo(2))
+
+		uptime := envelopesOf(events.Envelope_ValueMetric)[0].GetValueMetric()
+		Expect(uptime.GetName()).To(Equal("uptime"))
+		Expect(uptime.GetUnit()).To(Equal("s"))
+		Expect(uptime.GetValue()).To(BeNumerically(">", 0))
+	})
+
+	It("tags both with the build information and version tags", func() {
+		Eventually(func() int { return len(fakeEventEmitter.GetEnvelopes()) }).Should(BeNumerically(">=", 2))
+
+		for _, envelope := range fakeEventEmitter.GetEnvelopes()[:2] {
+			Expect(envelope.GetTags()).To(HaveKeyWithValue("release", "v42"))
+			Expect(envelope.GetTags()).To(HaveKeyWithValue(runtime_stats.GoVersionTag, "overridden"))
+		}
+	})
+})
+
+var _ = Describe("BuildInfoTags", func() {
+	It("includes the Go version", func() {
+		Expect(runtime_stats.BuildInfoTags()).To(HaveKeyWithValue(runtime_stats.GoVersionTag, runtime.Version()))
+	})
+})
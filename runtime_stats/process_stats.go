@@ -0,0 +1,235 @@
+package runtime_stats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/units"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat. It is 100 on
+// every Linux architecture Go supports.
+const clockTicks = 100
+
+// A ProcessOption configures a ProcessStats created by NewProcessStats.
+type ProcessOption func(*ProcessStats)
+
+// WithProcRoot reads process statistics from root instead of /proc.
+func WithProcRoot(root string) ProcessOption {
+	return func(ps *ProcessStats) {
+		ps.procRoot = root
+	}
+}
+
+// ProcessStats periodically emits statistics about the current process read
+// from the Linux proc filesystem: memory, CPU time, threads, open file
+// descriptors and I/O. Statistics that cannot be read, for example on other
+// operating systems, are skipped and the failure is logged once.
+type ProcessStats struct {
+	emitter  EventEmitter
+	interval time.Duration
+	procRoot string
+
+	// failed holds the files that could not be read, so that each failure
+	// is only logged once.
+	failed map[string]struct{}
+}
+
+// ProcessDescriptors declares the metrics ProcessStats emits, for
+// registration with a metric_registry.Registry.
+func ProcessDescriptors() []metric_registry.Descriptor {
+	gauge := func(name, unit, help string) metric_registry.Descriptor {
+		return metric_registry.Descriptor{Name: name, Kind: metric_registry.Gauge, Unit: unit, Help: help}
+	}
+	return []metric_registry.Descriptor{
+		gauge("processStats.residentMemory", units.Bytes, "Resident set size of the process."),
+		gauge("processStats.virtualMemory", units.Bytes, "Virtual memory size of the process."),
+		gauge("processStats.threads", units.Count, "Number of threads in the process."),
+		gauge("processStats.cpuUserTime", units.Seconds, "Cumulative CPU time spent in user mode."),
+		gauge("processStats.cpuSystemTime", units.Seconds, "Cumulative CPU time spent in kernel mode."),
+		gauge("processStats.openFDs", units.Count, "Number of open file descriptors."),
+		gauge("processStats.maxFDs", units.Count, "Limit on the number of open file descriptors."),
+		gauge("processStats.readBytes", units.Bytes, "Cumulative bytes read from storage."),
+		gauge("processStats.writeBytes", units.Bytes, "Cumulative bytes written to storage."),
+	}
+}
+
+func NewProcessStats(emitter EventEmitter, interval time.Duration, opts ...ProcessOption) *ProcessStats {
+	ps := &ProcessStats{
+		emitter:  emitter,
+		interval: interval,
+		procRoot: "/proc",
+		failed:   make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(ps)
+	}
+	return ps
+}
+
+func (ps *ProcessStats) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+	for {
+		ps.emitStatus()
+		ps.emitStat()
+		ps.emitFDs()
+		ps.emitIO()
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (ps *ProcessStats) emitStatus() {
+	fields, ok := ps.readFields("status")
+	if !ok {
+		return
+	}
+	ps.emitKB("processStats.residentMemory", fields["VmRSS"])
+	ps.emitKB("processStats.virtualMemory", fields["VmSize"])
+	ps.emitNumber("processStats.threads", fields["Threads"], units.Count)
+}
+
+// emitStat emits the CPU times, the 14th and 15th fields of stat. Fields are
+// counted after the command name, which is in parentheses and may itself
+// contain spaces.
+func (ps *ProcessStats) emitStat() {
+	data, ok := ps.read("stat")
+	if !ok {
+		return
+	}
+
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		ps.logOnce("stat", fmt.Errorf("malformed stat: %q", data))
+		return
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 13 {
+		ps.logOnce("stat", fmt.Errorf("malformed stat: %q", data))
+		return
+	}
+
+	utime, errU := strconv.ParseUint(fields[11], 10, 64)
+	stime, errS := strconv.ParseUint(fields[12], 10, 64)
+	if errU != nil || errS != nil {
+		ps.logOnce("stat", fmt.Errorf("malformed stat: %q", data))
+		return
+	}
+	ps.emit("processStats.cpuUserTime", float64(utime)/clockTicks, units.Seconds)
+	ps.emit("processStats.cpuSystemTime", float64(stime)/clockTicks, units.Seconds)
+}
+
+func (ps *ProcessStats) emitFDs() {
+	fds, err := os.ReadDir(ps.path("fd"))
+	if err != nil {
+		ps.logOnce("fd", err)
+	} else {
+		ps.emit("processStats.openFDs", float64(len(fds)), units.Count)
+	}
+
+	data, ok := ps.read("limits")
+	if !ok {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 || fields[0] == "unlimited" {
+			return
+		}
+		ps.emitNumber("processStats.maxFDs", fields[0], units.Count)
+		return
+	}
+}
+
+func (ps *ProcessStats) emitIO() {
+	fields, ok := ps.readFields("io")
+	if !ok {
+		return
+	}
+	ps.emitNumber("processStats.readBytes", fields["read_bytes"], units.Bytes)
+	ps.emitNumber("processStats.writeBytes", fields["write_bytes"], units.Bytes)
+}
+
+// readFields reads a file of "key: value" lines, such as status or io.
+func (ps *ProcessStats) readFields(name string) (map[string]string, bool) {
+	data, ok := ps.read(name)
+	if !ok {
+		return nil, false
+	}
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if found {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return fields, true
+}
+
+func (ps *ProcessStats) read(name string) ([]byte, bool) {
+	data, err := os.ReadFile(ps.path(name))
+	if err != nil {
+		ps.logOnce(name, err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (ps *ProcessStats) path(name string) string {
+	return filepath.Join(ps.procRoot, "self", name)
+}
+
+// emitKB emits a value such as "1024 kB" from status in bytes.
+func (ps *ProcessStats) emitKB(name, value string) {
+	kb, err := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+	if err != nil {
+		return
+	}
+	ps.emit(name, float64(kb*1024), units.Bytes)
+}
+
+func (ps *ProcessStats) emitNumber(name, value, unit string) {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return
+	}
+	ps.emit(name, float64(n), unit)
+}
+
+func (ps *ProcessStats) emit(name string, value float64, unit string) {
+	err := ps.emitter.Emit(&events.ValueMetric{
+		Name:  &name,
+		Value: &value,
+		Unit:  &unit,
+	})
+	if err != nil {
+		log.Printf("ProcessStats: failed to emit: %v", err)
+	}
+}
+
+func (ps *ProcessStats) logOnce(name string, err error) {
+	if _, ok := ps.failed[name]; ok {
+		return
+	}
+	ps.failed[name] = struct{}{}
+	log.Printf("ProcessStats: failed to read %s: %v", name, err)
+}
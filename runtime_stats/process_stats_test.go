@@ -0,0 +1,122 @@
+package runtime_stats_test
+
+import (
+	"bytes"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/runtime_stats"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProcessStats", func() {
+	var (
+		fakeEventEmitter  *fake.FakeEventEmitter
+		stopChan, runDone chan struct{}
+	)
+
+	BeforeEach(func() {
+		fakeEventEmitter = fake.NewFakeEventEmitter("fake-origin")
+		stopChan = make(chan struct{})
+		runDone = make(chan struct{})
+	})
+
+	AfterEach(func() {
+		close(stopChan)
+		Eventually(runDone).Should(BeClosed())
+	})
+
+	var perform = func(processStats *runtime_stats.ProcessStats) {
+		go func() {
+			processStats.Run(stopChan)
+			close(runDone)
+		}()
+	}
+
+	var getMetrics = func() map[string]*events.ValueMetric {
+		metrics := make(map[string]*events.ValueMetric)
+		for _, event := range fakeEventEmitter.GetEvents() {
+			metric := event.(*events.ValueMetric)
+			metrics[metric.GetName()] = metric
+		}
+		return metrics
+	}
+
+	var expectMetric = func(name string, value float64, unit string) {
+		ExpectWithOffset(1, getMetrics()).To(HaveKey(name))
+		ExpectWithOffset(1, getMetrics()[name].GetValue()).To(BeNumerically("~", value, 1e-9))
+		ExpectWithOffset(1, getMetrics()[name].GetUnit()).To(Equal(unit))
+	}
+
+	It("emits the statistics of the fixture process", func() {
+		perform(runtime_stats.NewProcessStats(fakeEventEmitter, time.Hour, runtime_stats.WithProcRoot("testdata/proc")))
+
+		Eventually(getMetrics).Should(HaveKey("processStats.writeBytes"))
+		expectMetric("processStats.residentMemory", 20480*1024, "bytes")
+		expectMetric("processStats.virtualMemory", 716800*1024, "bytes")
+		expectMetric("processStats.threads", 12, "count")
+		expectMetric("processStats.cpuUserTime", 2.5, "s")
+		expectMetric("processStats.cpuSystemTime", 0.75, "s")
+		expectMetric("processStats.openFDs", 4, "count")
+		expectMetric("processStats.maxFDs", 1024, "count")
+		expectMetric("processStats.readBytes", 4096, "bytes")
+		expectMetric("processStats.writeBytes", 8192, "bytes")
+	})
+
+	It("declares every metric it emits", func() {
+		registry := metric_registry.New(metric_registry.Strict)
+		Expect(registry.Register(runtime_stats.ProcessDescriptors()...)).To(Succeed())
+		perform(runtime_stats.NewProcessStats(fakeEventEmitter, time.Hour, runtime_stats.WithProcRoot("testdata/proc")))
+
+		Eventually(getMetrics).Should(HaveLen(len(runtime_stats.ProcessDescriptors())))
+		for _, metric := range getMetrics() {
+			Expect(registry.Check(metric.GetName(), metric_registry.Gauge, metric.GetUnit())).To(Succeed())
+		}
+	})
+
+	It("logs each file it cannot read once", func() {
+		logOutput := new(safeBuffer)
+		log.SetOutput(logOutput)
+		perform(runtime_stats.NewProcessStats(fakeEventEmitter, 10*time.Millisecond, runtime_stats.WithProcRoot("testdata/missing")))
+
+		Eventually(logOutput.String).Should(ContainSubstring("failed to read io"))
+		Consistently(func() int { return strings.Count(logOutput.String(), "failed to read status") }, 50*time.Millisecond).Should(Equal(1))
+		Expect(fakeEventEmitter.GetEvents()).To(BeEmpty())
+	})
+
+	It("reads the current process by default", func() {
+		if runtime.GOOS != "linux" {
+			Skip("the proc filesystem is only available on Linux")
+		}
+		perform(runtime_stats.NewProcessStats(fakeEventEmitter, time.Hour))
+
+		Eventually(getMetrics).Should(HaveKey("processStats.openFDs"))
+		Expect(getMetrics()["processStats.residentMemory"].GetValue()).To(BeNumerically(">", 0))
+		Expect(getMetrics()["processStats.openFDs"].GetValue()).To(BeNumerically(">", 0))
+	})
+})
+
+type safeBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *safeBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
@@ -1,37 +1,154 @@
+// Package runtime_stats periodically emits statistics about the Go runtime
+// as ValueMetrics.
+//
+// Statistics are read from the runtime/metrics package, which, unlike
+// runtime.ReadMemStats, does not stop the world. Besides the numCPUS,
+// numGoRoutines and memoryStats metrics that are always emitted, the
+// runtime/metrics in DefaultMetrics, or those given to WithMetrics, are
+// emitted under names derived from theirs: "/gc/cycles/total:gc-cycles" is
+// emitted as "runtime.gc.cycles.total". Histograms are summarised by
+// percentiles over each interval, emitted with a suffix such as ".p99".
 package runtime_stats
 
 import (
+	"fmt"
 	"log"
+	"math"
 	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
 	"time"
 
+	"github.com/cloudfoundry/dropsonde/metric_registry"
+	"github.com/cloudfoundry/dropsonde/units"
 	"github.com/cloudfoundry/sonde-go/events"
-	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// DefaultMetrics are the runtime/metrics emitted unless configured
+	// otherwise. Metrics the running Go version does not support are skipped.
+	DefaultMetrics = []string{
+		"/gc/cycles/total:gc-cycles",
+		"/gc/pauses:seconds",
+		"/gc/heap/objects:objects",
+		"/sched/latencies:seconds",
+		"/sched/gomaxprocs:threads",
+		"/sync/mutex/wait/total:seconds",
+	}
+
+	// DefaultPercentiles are the percentiles histograms are summarised by
+	// unless configured otherwise.
+	DefaultPercentiles = []float64{0.5, 0.9, 0.99}
+)
+
+// memoryStats are the runtime/metrics the memoryStats metrics are read from.
+const (
+	heapObjectsBytes = "/memory/classes/heap/objects:bytes"
+	stackBytes       = "/memory/classes/heap/stacks:bytes"
+	heapAllocs       = "/gc/heap/allocs:objects"
+	heapFrees        = "/gc/heap/frees:objects"
 )
 
 type EventEmitter interface {
 	Emit(events.Event) error
 }
 
+// An Option configures a RuntimeStats created by NewRuntimeStats.
+type Option func(*RuntimeStats)
+
+// WithMetrics sets the runtime/metrics that are emitted, by their
+// runtime/metrics names, in place of DefaultMetrics.
+func WithMetrics(names ...string) Option {
+	return func(rs *RuntimeStats) {
+		rs.metricNames = names
+	}
+}
+
+// WithPercentiles sets the percentiles, between 0 and 1, histograms are
+// summarised by.
+func WithPercentiles(percentiles ...float64) Option {
+	return func(rs *RuntimeStats) {
+		rs.percentiles = percentiles
+	}
+}
+
 type RuntimeStats struct {
-	emitter  EventEmitter
-	interval time.Duration
+	emitter     EventEmitter
+	interval    time.Duration
+	metricNames []string
+	percentiles []float64
+
+	samples  []metrics.Sample
+	previous map[string][]uint64
+	gcStats  debug.GCStats
+}
+
+// Descriptors declares the metrics a RuntimeStats with the default options
+// emits, for registration with a metric_registry.Registry.
+func Descriptors() []metric_registry.Descriptor {
+	return NewRuntimeStats(nil, 0).Descriptors()
+}
+
+// Descriptors declares the metrics rs emits, for registration with a
+// metric_registry.Registry.
+func (rs *RuntimeStats) Descriptors() []metric_registry.Descriptor {
+	gauge := func(name, unit, help string) metric_registry.Descriptor {
+		return metric_registry.Descriptor{Name: name, Kind: metric_registry.Gauge, Unit: unit, Help: help}
+	}
+	descriptors := []metric_registry.Descriptor{
+		gauge("numCPUS", units.Count, "Number of logical CPUs usable by the process."),
+		gauge("numGoRoutines", units.Count, "Number of goroutines that currently exist."),
+		gauge("memoryStats.numBytesAllocatedHeap", units.Bytes, "Bytes of allocated heap objects."),
+		gauge("memoryStats.numBytesAllocatedStack", units.Bytes, "Bytes in stack spans."),
+		gauge("memoryStats.numBytesAllocated", units.Bytes, "Bytes of allocated heap objects."),
+		gauge("memoryStats.numMallocs", units.Count, "Cumulative count of heap objects allocated."),
+		gauge("memoryStats.numFrees", units.Count, "Cumulative count of heap objects freed."),
+		gauge("memoryStats.lastGCPauseTimeNS", units.Nanoseconds, "Duration of the most recent garbage collection pause."),
+	}
+
+	for _, d := range supportedMetrics(rs.metricNames) {
+		name, unit := metricName(d.Name), metricUnit(d.Name)
+		if d.Kind != metrics.KindFloat64Histogram {
+			descriptors = append(descriptors, gauge(name, unit, d.Description))
+			continue
+		}
+		for _, p := range rs.percentiles {
+			help := fmt.Sprintf("%s (%s over the interval)", d.Description, percentileSuffix(p)[1:])
+			descriptors = append(descriptors, gauge(name+percentileSuffix(p), unit, help))
+		}
+	}
+	return descriptors
 }
 
-func NewRuntimeStats(emitter EventEmitter, interval time.Duration) *RuntimeStats {
-	return &RuntimeStats{
-		emitter:  emitter,
-		interval: interval,
+func NewRuntimeStats(emitter EventEmitter, interval time.Duration, opts ...Option) *RuntimeStats {
+	rs := &RuntimeStats{
+		emitter:     emitter,
+		interval:    interval,
+		metricNames: DefaultMetrics,
+		percentiles: DefaultPercentiles,
+		previous:    make(map[string][]uint64),
 	}
+	for _, opt := range opts {
+		opt(rs)
+	}
+
+	for _, name := range []string{heapObjectsBytes, stackBytes, heapAllocs, heapFrees} {
+		rs.samples = append(rs.samples, metrics.Sample{Name: name})
+	}
+	for _, d := range supportedMetrics(rs.metricNames) {
+		rs.samples = append(rs.samples, metrics.Sample{Name: d.Name})
+	}
+	return rs
 }
 
 func (rs *RuntimeStats) Run(stopChan <-chan struct{}) {
 	ticker := time.NewTicker(rs.interval)
 	defer ticker.Stop()
 	for {
-		rs.emit("numCPUS", float64(runtime.NumCPU()))
-		rs.emit("numGoRoutines", float64(runtime.NumGoroutine()))
-		rs.emitMemMetrics()
+		rs.emit("numCPUS", float64(runtime.NumCPU()), units.Count)
+		rs.emit("numGoRoutines", float64(runtime.NumGoroutine()), units.Count)
+		rs.emitRuntimeMetrics()
 
 		select {
 		case <-ticker.C:
@@ -41,25 +158,149 @@ func (rs *RuntimeStats) Run(stopChan <-chan struct{}) {
 	}
 }
 
-func (rs *RuntimeStats) emitMemMetrics() {
-	stats := new(runtime.MemStats)
-	runtime.ReadMemStats(stats)
+func (rs *RuntimeStats) emitRuntimeMetrics() {
+	metrics.Read(rs.samples)
+
+	memory := rs.samples[:4]
+	rs.emit("memoryStats.numBytesAllocatedHeap", sampleValue(memory[0].Value), units.Bytes)
+	rs.emit("memoryStats.numBytesAllocatedStack", sampleValue(memory[1].Value), units.Bytes)
+	rs.emit("memoryStats.numBytesAllocated", sampleValue(memory[0].Value), units.Bytes)
+	rs.emit("memoryStats.numMallocs", sampleValue(memory[2].Value), units.Count)
+	rs.emit("memoryStats.numFrees", sampleValue(memory[3].Value), units.Count)
+	rs.emitLastGCPause()
+
+	for _, sample := range rs.samples[4:] {
+		name, unit := metricName(sample.Name), metricUnit(sample.Name)
+		if sample.Value.Kind() != metrics.KindFloat64Histogram {
+			rs.emit(name, sampleValue(sample.Value), unit)
+			continue
+		}
 
-	rs.emit("memoryStats.numBytesAllocatedHeap", float64(stats.HeapAlloc))
-	rs.emit("memoryStats.numBytesAllocatedStack", float64(stats.StackInuse))
-	rs.emit("memoryStats.numBytesAllocated", float64(stats.Alloc))
-	rs.emit("memoryStats.numMallocs", float64(stats.Mallocs))
-	rs.emit("memoryStats.numFrees", float64(stats.Frees))
-	rs.emit("memoryStats.lastGCPauseTimeNS", float64(stats.PauseNs[(stats.NumGC+255)%256]))
+		h := sample.Value.Float64Histogram()
+		counts := rs.intervalCounts(sample.Name, h.Counts)
+		for _, p := range rs.percentiles {
+			if value, ok := percentile(h.Buckets, counts, p); ok {
+				rs.emit(name+percentileSuffix(p), value, unit)
+			}
+		}
+	}
 }
 
-func (rs *RuntimeStats) emit(name string, value float64) {
+// emitLastGCPause reads the most recent pause with debug.ReadGCStats, which,
+// unlike runtime.ReadMemStats, does not stop the world.
+func (rs *RuntimeStats) emitLastGCPause() {
+	debug.ReadGCStats(&rs.gcStats)
+
+	var pause time.Duration
+	if len(rs.gcStats.Pause) > 0 {
+		pause = rs.gcStats.Pause[0]
+	}
+	rs.emit("memoryStats.lastGCPauseTimeNS", float64(pause), units.Nanoseconds)
+}
+
+// intervalCounts returns the counts of the named histogram since it was last
+// read. Runtime histograms are cumulative since the process started.
+func (rs *RuntimeStats) intervalCounts(name string, counts []uint64) []uint64 {
+	previous := rs.previous[name]
+	if len(previous) != len(counts) {
+		previous = make([]uint64, len(counts))
+	}
+
+	interval := make([]uint64, len(counts))
+	for i, c := range counts {
+		interval[i] = c - previous[i]
+	}
+	rs.previous[name] = append(previous[:0], counts...)
+	return interval
+}
+
+func (rs *RuntimeStats) emit(name string, value float64, unit string) {
 	err := rs.emitter.Emit(&events.ValueMetric{
 		Name:  &name,
 		Value: &value,
-		Unit:  proto.String("count"),
+		Unit:  &unit,
 	})
 	if err != nil {
 		log.Printf("RuntimeStats: failed to emit: %v", err)
 	}
 }
+
+// percentile estimates the p-th percentile of a runtime histogram as the
+// upper bound of the bucket it falls into, or the lower bound for the last,
+// unbounded bucket. It returns false if the histogram is empty.
+func percentile(buckets []float64, counts []uint64, p float64) (float64, bool) {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := uint64(math.Ceil(p * float64(total)))
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		if c == 0 || cumulative < rank {
+			continue
+		}
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper, true
+		}
+		return buckets[i], true
+	}
+	return buckets[len(buckets)-1], true
+}
+
+func supportedMetrics(names []string) []metrics.Description {
+	supported := make(map[string]metrics.Description)
+	for _, d := range metrics.All() {
+		supported[d.Name] = d
+	}
+
+	var descriptions []metrics.Description
+	for _, name := range names {
+		if d, ok := supported[name]; ok {
+			descriptions = append(descriptions, d)
+		}
+	}
+	return descriptions
+}
+
+func sampleValue(v metrics.Value) float64 {
+	switch v.Kind() {
+	case metrics.KindUint64:
+		return float64(v.Uint64())
+	case metrics.KindFloat64:
+		return v.Float64()
+	}
+	return 0
+}
+
+// metricName derives the emitted name of a runtime/metric, for example
+// "runtime.gc.heap.objects" from "/gc/heap/objects:objects".
+func metricName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	return "runtime" + strings.ReplaceAll(name, "/", ".")
+}
+
+// metricUnit maps the unit of a runtime/metric to a canonical unit of
+// package units.
+func metricUnit(name string) string {
+	unit := name[strings.IndexByte(name, ':')+1:]
+	switch unit {
+	case "bytes":
+		return units.Bytes
+	case "seconds", "cpu-seconds":
+		return units.Seconds
+	}
+	return units.Count
+}
+
+// percentileSuffix names a percentile, for example ".p99" for 0.99 and
+// ".p99_9" for 0.999.
+func percentileSuffix(p float64) string {
+	return ".p" + strings.ReplaceAll(fmt.Sprintf("%g", math.Round(p*1e6)/1e4), ".", "_")
+}
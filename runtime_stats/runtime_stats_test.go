@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/metric_registry"
 	"github.com/cloudfoundry/sonde-go/events"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -82,6 +83,85 @@ var _ = Describe("RuntimeStats", func() {
 		Eventually(getMetricNames).Should(ContainElement("memoryStats.lastGCPauseTimeNS"))
 	})
 
+	It("emits memoryStats metrics with their units", func() {
+		perform()
+
+		var getUnits = func() map[string]string {
+			units := make(map[string]string)
+			for _, event := range fakeEventEmitter.GetEvents() {
+				metric := event.(*events.ValueMetric)
+				units[metric.GetName()] = metric.GetUnit()
+			}
+			return units
+		}
+
+		Eventually(getUnits).Should(HaveKeyWithValue("memoryStats.numBytesAllocatedHeap", "bytes"))
+		Expect(getUnits()).To(HaveKeyWithValue("memoryStats.numBytesAllocatedStack", "bytes"))
+		Expect(getUnits()).To(HaveKeyWithValue("memoryStats.numBytesAllocated", "bytes"))
+		Expect(getUnits()).To(HaveKeyWithValue("memoryStats.numMallocs", "count"))
+		Expect(getUnits()).To(HaveKeyWithValue("memoryStats.lastGCPauseTimeNS", "ns"))
+	})
+
+	Context("runtime/metrics", func() {
+		var getUnits = func() map[string]string {
+			units := make(map[string]string)
+			for _, event := range fakeEventEmitter.GetEvents() {
+				metric := event.(*events.ValueMetric)
+				units[metric.GetName()] = metric.GetUnit()
+			}
+			return units
+		}
+
+		It("emits the default metrics with their units", func() {
+			perform()
+
+			Eventually(getUnits).Should(HaveKeyWithValue("runtime.gc.cycles.total", "count"))
+			Expect(getUnits()).To(HaveKeyWithValue("runtime.gc.heap.objects", "count"))
+			Expect(getUnits()).To(HaveKeyWithValue("runtime.sync.mutex.wait.total", "s"))
+			Expect(fakeEventEmitter.GetEvents()).To(ContainElement(&events.ValueMetric{
+				Name:  proto.String("runtime.sched.gomaxprocs"),
+				Value: proto.Float64(float64(runtime.GOMAXPROCS(0))),
+				Unit:  proto.String("count"),
+			}))
+		})
+
+		It("summarises histograms by percentiles", func() {
+			runtime.GC()
+			perform()
+
+			Eventually(getUnits).Should(HaveKeyWithValue("runtime.gc.pauses.p50", "s"))
+			Expect(getUnits()).To(HaveKeyWithValue("runtime.gc.pauses.p90", "s"))
+			Expect(getUnits()).To(HaveKeyWithValue("runtime.gc.pauses.p99", "s"))
+		})
+
+		It("emits the configured metrics and percentiles", func() {
+			runtimeStats = runtime_stats.NewRuntimeStats(fakeEventEmitter, 10*time.Millisecond,
+				runtime_stats.WithMetrics("/gc/pauses:seconds", "/not/a/metric:bytes"),
+				runtime_stats.WithPercentiles(0.999),
+			)
+			runtime.GC()
+			perform()
+
+			Eventually(getMetricNames).Should(ContainElement("runtime.gc.pauses.p99_9"))
+			Expect(getMetricNames()).To(ContainElement("memoryStats.numMallocs"))
+			Expect(getMetricNames()).ToNot(ContainElement("runtime.gc.cycles.total"))
+			Expect(getMetricNames()).ToNot(ContainElement("runtime.gc.pauses.p50"))
+			Expect(getMetricNames()).ToNot(ContainElement("runtime.not.a.metric"))
+		})
+	})
+
+	It("declares every metric it emits", func() {
+		registry := metric_registry.New(metric_registry.Strict)
+		Expect(registry.Register(runtime_stats.Descriptors()...)).To(Succeed())
+		perform()
+
+		Eventually(getMetricNames).Should(ContainElement("runtime.sched.gomaxprocs"))
+		for _, event := range fakeEventEmitter.GetEvents() {
+			metric := event.(*events.ValueMetric)
+			Expect(registry.Check(metric.GetName(), metric_registry.Gauge, metric.GetUnit())).To(Succeed())
+		}
+	})
+
 	It("logs an error if emitting fails", func() {
 		fakeEventEmitter.ReturnError = errors.New("fake error")
 		fakeLogWriter := &fakeLogWriter{make(chan []byte)}
@@ -91,6 +171,19 @@ var _ = Describe("RuntimeStats", func() {
 	})
 })
 
+var _ = Describe("Descriptors", func() {
+	It("declares the configured metrics", func() {
+		descriptors := runtime_stats.NewRuntimeStats(nil, 0, runtime_stats.WithMetrics("/gc/pauses:seconds")).Descriptors()
+
+		var names []string
+		for _, d := range descriptors {
+			names = append(names, d.Name)
+		}
+		Expect(names).To(ContainElements("numCPUS", "runtime.gc.pauses.p50", "runtime.gc.pauses.p99"))
+		Expect(names).ToNot(ContainElement("runtime.gc.cycles.total"))
+	})
+})
+
 type fakeLogWriter struct {
 	writeChan chan []byte
 }
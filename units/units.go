@@ -0,0 +1,180 @@
+// Package units defines the canonical units of ValueMetrics, and converts
+// values between them.
+//
+// Units follow the recommendations of http://metrics20.org/spec/#units,
+// spelled the way dropsonde components already send them: "ms" and "s" for
+// durations, "bytes" for sizes and "count" for quantities without a unit.
+// Normalize maps the other spellings seen in the wild, such as "nanos",
+// "milliseconds" or "B", to their canonical unit.
+package units
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Canonical units.
+const (
+	Nanoseconds  = "ns"
+	Microseconds = "us"
+	Milliseconds = "ms"
+	Seconds      = "s"
+	Minutes      = "min"
+	Hours        = "h"
+
+	Bytes     = "bytes"
+	Kilobytes = "kB"
+	Megabytes = "MB"
+	Gigabytes = "GB"
+	Kibibytes = "KiB"
+	Mebibytes = "MiB"
+	Gibibytes = "GiB"
+
+	Percent = "percent"
+	Ratio   = "ratio"
+
+	Count     = "count"
+	PerSecond = "per_second"
+)
+
+// Mode selects how a sender treats the units of the metrics it sends.
+type Mode int
+
+const (
+	// Unchecked sends units as they are given.
+	Unchecked Mode = iota
+	// Permissive replaces known spellings of a unit by the canonical unit,
+	// and sends unknown units as they are given.
+	Permissive
+	// Strict replaces known spellings of a unit by the canonical unit, and
+	// rejects unknown units.
+	Strict
+)
+
+type dimension int
+
+const (
+	duration dimension = iota
+	size
+	fraction
+	quantity
+	rate
+)
+
+type definition struct {
+	dimension dimension
+	// scale is the value of one of the unit in the smallest unit of its
+	// dimension, so that scales are whole numbers.
+	scale float64
+}
+
+var canonical = map[string]definition{
+	Nanoseconds:  {duration, 1},
+	Microseconds: {duration, 1e3},
+	Milliseconds: {duration, 1e6},
+	Seconds:      {duration, 1e9},
+	Minutes:      {duration, 60e9},
+	Hours:        {duration, 3600e9},
+
+	Bytes:     {size, 1},
+	Kilobytes: {size, 1e3},
+	Megabytes: {size, 1e6},
+	Gigabytes: {size, 1e9},
+	Kibibytes: {size, 1 << 10},
+	Mebibytes: {size, 1 << 20},
+	Gibibytes: {size, 1 << 30},
+
+	Percent: {fraction, 1},
+	Ratio:   {fraction, 100},
+
+	Count:     {quantity, 1},
+	PerSecond: {rate, 1},
+}
+
+// symbols are spellings of canonical units in which case matters, such as
+// "B" for bytes, which is not "b" for bits.
+var symbols = map[string]string{
+	"B":  Bytes,
+	"µs": Microseconds,
+	"%":  Percent,
+}
+
+// words are spellings of canonical units in which case does not matter,
+// in lower case.
+var words = map[string]string{
+	"nanos": Nanoseconds, "nanosecond": Nanoseconds, "nanoseconds": Nanoseconds, "nsec": Nanoseconds,
+	"micros": Microseconds, "microsecond": Microseconds, "microseconds": Microseconds, "usec": Microseconds,
+	"millis": Milliseconds, "millisecond": Milliseconds, "milliseconds": Milliseconds, "msec": Milliseconds,
+	"sec": Seconds, "secs": Seconds, "second": Seconds, "seconds": Seconds,
+	"mins": Minutes, "minute": Minutes, "minutes": Minutes,
+	"hour": Hours, "hours": Hours,
+
+	"byte": Bytes, "bytes": Bytes,
+	"kilobyte": Kilobytes, "kilobytes": Kilobytes,
+	"megabyte": Megabytes, "megabytes": Megabytes,
+	"gigabyte": Gigabytes, "gigabytes": Gigabytes,
+	"kib": Kibibytes, "kibibyte": Kibibytes, "kibibytes": Kibibytes,
+	"mib": Mebibytes, "mebibyte": Mebibytes, "mebibytes": Mebibytes,
+	"gib": Gibibytes, "gibibyte": Gibibytes, "gibibytes": Gibibytes,
+
+	"pct": Percent, "percentage": Percent,
+	"counts":    Count,
+	"persecond": PerSecond, "per_sec": PerSecond, "/s": PerSecond,
+}
+
+// IsCanonical reports whether unit is one of the canonical units.
+func IsCanonical(unit string) bool {
+	_, ok := canonical[unit]
+	return ok
+}
+
+// Normalize returns the canonical unit for unit. It reports false, and
+// returns unit unchanged, if unit is not a known spelling of a canonical
+// unit.
+func Normalize(unit string) (string, bool) {
+	if IsCanonical(unit) {
+		return unit, true
+	}
+	if c, ok := symbols[unit]; ok {
+		return c, true
+	}
+	if c, ok := words[strings.ToLower(unit)]; ok {
+		return c, true
+	}
+	return unit, false
+}
+
+// Convert converts value from one unit to another. Both units may be given
+// in any spelling Normalize knows. It returns an error if either unit is
+// unknown, or if they measure different things.
+func Convert(value float64, from, to string) (float64, error) {
+	f, ok := lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("units: unknown unit %q", from)
+	}
+	t, ok := lookup(to)
+	if !ok {
+		return 0, fmt.Errorf("units: unknown unit %q", to)
+	}
+	if f.dimension != t.dimension {
+		return 0, fmt.Errorf("units: cannot convert %s to %s", from, to)
+	}
+	if f.scale == t.scale {
+		return value, nil
+	}
+	return value * f.scale / t.scale, nil
+}
+
+// FromDuration returns d in the given unit of duration.
+func FromDuration(d time.Duration, unit string) (float64, error) {
+	return Convert(float64(d), Nanoseconds, unit)
+}
+
+func lookup(unit string) (definition, bool) {
+	c, ok := Normalize(unit)
+	if !ok {
+		return definition{}, false
+	}
+	return canonical[c], true
+}
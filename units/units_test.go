@@ -0,0 +1,77 @@
+package units_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/units"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Units", func() {
+	Describe("Normalize", func() {
+		DescribeTable("maps spellings to canonical units",
+			func(unit, expected string) {
+				normalized, ok := units.Normalize(unit)
+				Expect(ok).To(BeTrue())
+				Expect(normalized).To(Equal(expected))
+			},
+			Entry("canonical", "ms", units.Milliseconds),
+			Entry("nanos", "nanos", units.Nanoseconds),
+			Entry("words in any case", "Milliseconds", units.Milliseconds),
+			Entry("B", "B", units.Bytes),
+			Entry("binary prefixes", "mib", units.Mebibytes),
+			Entry("%", "%", units.Percent),
+		)
+
+		It("reports unknown units and returns them unchanged", func() {
+			normalized, ok := units.Normalize("furlongs")
+			Expect(ok).To(BeFalse())
+			Expect(normalized).To(Equal("furlongs"))
+		})
+
+		It("does not take b for bytes", func() {
+			_, ok := units.Normalize("b")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("IsCanonical", func() {
+		It("only accepts canonical spellings", func() {
+			Expect(units.IsCanonical("bytes")).To(BeTrue())
+			Expect(units.IsCanonical("B")).To(BeFalse())
+		})
+	})
+
+	Describe("Convert", func() {
+		DescribeTable("converts between units of the same kind",
+			func(value float64, from, to string, expected float64) {
+				Expect(units.Convert(value, from, to)).To(Equal(expected))
+			},
+			Entry("ms to ns", 1.5, "ms", "ns", 1500000.0),
+			Entry("ns to s", 250000000.0, "nanos", "s", 0.25),
+			Entry("min to s", 2.0, "min", "seconds", 120.0),
+			Entry("MiB to bytes", 3.0, "MiB", "bytes", 3.0*1024*1024),
+			Entry("bytes to kB", 1500.0, "B", "kB", 1.5),
+			Entry("ratio to percent", 0.25, "ratio", "percent", 25.0),
+			Entry("percent to ratio", 50.0, "%", "ratio", 0.5),
+		)
+
+		It("rejects units of different kinds", func() {
+			_, err := units.Convert(1, "ms", "bytes")
+			Expect(err).To(MatchError("units: cannot convert ms to bytes"))
+		})
+
+		It("rejects unknown units", func() {
+			_, err := units.Convert(1, "ms", "furlongs")
+			Expect(err).To(MatchError(`units: unknown unit "furlongs"`))
+		})
+	})
+
+	Describe("FromDuration", func() {
+		It("converts durations", func() {
+			Expect(units.FromDuration(1500*time.Microsecond, units.Milliseconds)).To(Equal(1.5))
+		})
+	})
+})